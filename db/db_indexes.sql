@@ -1,4 +1,19 @@
 CREATE INDEX IF NOT EXISTS idx_pages_url ON pages(url);
 CREATE INDEX IF NOT EXISTS idx_pages_qdrant_id ON pages(qdrant_id);
 CREATE INDEX IF NOT EXISTS idx_links_from_page_id ON links(from_page_id);
-CREATE INDEX IF NOT EXISTS idx_links_to_url ON links(to_url);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_links_to_url ON links(to_url);
+CREATE INDEX IF NOT EXISTS idx_crawl_frontier_status ON crawl_frontier(status);
+CREATE INDEX IF NOT EXISTS idx_crawl_frontier_status_score ON crawl_frontier(status, score DESC);
+CREATE INDEX IF NOT EXISTS idx_pages_next_crawl_at ON pages(next_crawl_at);
+CREATE INDEX IF NOT EXISTS idx_pages_simhash_b0 ON pages(simhash_b0);
+CREATE INDEX IF NOT EXISTS idx_pages_simhash_b1 ON pages(simhash_b1);
+CREATE INDEX IF NOT EXISTS idx_pages_simhash_b2 ON pages(simhash_b2);
+CREATE INDEX IF NOT EXISTS idx_pages_simhash_b3 ON pages(simhash_b3);
+CREATE INDEX IF NOT EXISTS idx_pages_duplicate_of ON pages(duplicate_of);
+CREATE INDEX IF NOT EXISTS idx_pages_language ON pages(language);
+CREATE INDEX IF NOT EXISTS idx_pages_entity_type ON pages(entity_type);
+CREATE INDEX IF NOT EXISTS idx_pages_published_at ON pages(published_at);
+CREATE INDEX IF NOT EXISTS idx_url_aliases_canonical_url ON url_aliases(canonical_url);
+CREATE INDEX IF NOT EXISTS idx_failed_urls_next_retry_at ON failed_urls(next_retry_at);
+CREATE INDEX IF NOT EXISTS idx_fetch_attempts_host_attempted_at ON fetch_attempts(host, attempted_at);
+CREATE INDEX IF NOT EXISTS idx_fetch_attempts_attempted_at ON fetch_attempts(attempted_at);
\ No newline at end of file
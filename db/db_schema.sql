@@ -5,6 +5,29 @@ CREATE TABLE IF NOT EXISTS pages (
 		title TEXT,
 		status_code INTEGER,
 		favicon TEXT,
+		etag TEXT,
+		last_modified TIMESTAMP WITHOUT TIME ZONE,
+		content_hash CHARACTER(64),
+		recrawl_interval INTEGER,
+		last_checked_at TIMESTAMP WITHOUT TIME ZONE,
+		next_crawl_at TIMESTAMP WITHOUT TIME ZONE,
+		simhash BIGINT,
+		simhash_b0 INTEGER,
+		simhash_b1 INTEGER,
+		simhash_b2 INTEGER,
+		simhash_b3 INTEGER,
+		duplicate_of TEXT,
+		language CHARACTER VARYING(8),
+		encoding CHARACTER VARYING(40),
+		content_type TEXT,
+		page_count INTEGER,
+		entity_type CHARACTER VARYING(64),
+		structured_data JSONB,
+		published_at TIMESTAMP WITHOUT TIME ZONE,
+		modified_at TIMESTAMP WITHOUT TIME ZONE,
+		authors TEXT[],
+		redirect_chain TEXT[],
+		ttfb_ms INTEGER,
 		crawl_date TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
 	);
@@ -18,3 +41,69 @@ CREATE TABLE IF NOT EXISTS links (
 		link_type CHARACTER VARYING(20) DEFAULT 'external',
 		created_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
 	);
+
+
+CREATE TABLE IF NOT EXISTS crawl_frontier (
+		id BIGSERIAL PRIMARY KEY,
+		url TEXT NOT NULL UNIQUE,
+		anchor_text TEXT,
+		status CHARACTER VARYING(20) NOT NULL DEFAULT 'queued',
+		priority REAL DEFAULT 0,
+		lastmod TIMESTAMP WITHOUT TIME ZONE,
+		change_freq CHARACTER VARYING(20),
+		depth INTEGER DEFAULT 0,
+		inlinks INTEGER DEFAULT 0,
+		score REAL DEFAULT 0,
+		relevance REAL DEFAULT 0,
+		enqueued_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);
+
+
+CREATE TABLE IF NOT EXISTS url_aliases (
+		url TEXT PRIMARY KEY,
+		canonical_url TEXT NOT NULL,
+		reason CHARACTER VARYING(20) NOT NULL,
+		created_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);
+
+
+CREATE TABLE IF NOT EXISTS failed_urls (
+		url TEXT PRIMARY KEY,
+		anchor_text TEXT,
+		depth INTEGER DEFAULT 0,
+		outcome CHARACTER VARYING(20) NOT NULL,
+		status_code INTEGER,
+		error TEXT,
+		attempts INTEGER NOT NULL DEFAULT 1,
+		first_failed_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		last_failed_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		next_retry_at TIMESTAMP WITHOUT TIME ZONE
+	);
+
+
+CREATE TABLE IF NOT EXISTS fetch_attempts (
+		id BIGSERIAL PRIMARY KEY,
+		url TEXT NOT NULL,
+		host TEXT NOT NULL,
+		status_code INTEGER,
+		outcome CHARACTER VARYING(20) NOT NULL,
+		error TEXT,
+		response_time_ms INTEGER,
+		ttfb_ms INTEGER,
+		proxy TEXT,
+		attempted_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);
+
+
+CREATE TABLE IF NOT EXISTS crawl_traps (
+		host TEXT NOT NULL,
+		pattern TEXT NOT NULL,
+		reason CHARACTER VARYING(20) NOT NULL,
+		blocked BOOLEAN NOT NULL DEFAULT FALSE,
+		suppressed INTEGER NOT NULL DEFAULT 0,
+		detected_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (host, pattern)
+	);
@@ -55,7 +55,7 @@ func CreatePageEmbeddingsCollection() error {
 		return fmt.Errorf("failed to check if page_content_embeddings collection exists: %w", err)
 	}
 	if exists {
-		return nil // Collection already exists
+		return createPayloadIndexes() // Collection already exists
 	}
 
 	err = Client.CreateCollection(context.Background(), &qdrant.CreateCollection{
@@ -69,14 +69,62 @@ func CreatePageEmbeddingsCollection() error {
 		return fmt.Errorf("failed to create dashs_embedding collection: %w", err)
 	}
 
+	return createPayloadIndexes()
+}
+
+// payload fields used in search filters, indexed so filtering does not scan every point
+var payloadIndexes = map[string]qdrant.FieldType{
+	"language":     qdrant.FieldType_FieldTypeKeyword,
+	"entity_type":  qdrant.FieldType_FieldTypeKeyword,
+	"published_at": qdrant.FieldType_FieldTypeInteger,
+}
+
+// createPayloadIndexes is safe to call on every start, existing indexes are left as they are
+func createPayloadIndexes() error {
+	for field, fieldType := range payloadIndexes {
+		_, err := Client.CreateFieldIndex(context.Background(), &qdrant.CreateFieldIndexCollection{
+			CollectionName: QDRANT_COLLECTION_NAME,
+			FieldName:      field,
+			FieldType:      fieldType.Enum(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create %s payload index: %w", field, err)
+		}
+	}
+
 	return nil
 }
 
-func SearchPoints(ctx context.Context, vector models.EmbeddingModel) (*[]models.PagePoint, error) {
+// SearchPoints returns the closest pages that match the filters
+func SearchPoints(ctx context.Context, vector models.EmbeddingModel, filters models.SearchFilters) (*[]models.PagePoint, error) {
+
+	var conditions []*qdrant.Condition
+	if filters.Language != "" {
+		conditions = append(conditions, qdrant.NewMatch("language", filters.Language))
+	}
+	if filters.EntityType != "" {
+		conditions = append(conditions, qdrant.NewMatch("entity_type", filters.EntityType))
+	}
+	if !filters.PublishedAfter.IsZero() || !filters.PublishedBefore.IsZero() {
+		dateRange := &qdrant.Range{}
+		if !filters.PublishedAfter.IsZero() {
+			dateRange.Gte = qdrant.PtrOf(float64(filters.PublishedAfter.Unix()))
+		}
+		if !filters.PublishedBefore.IsZero() {
+			dateRange.Lte = qdrant.PtrOf(float64(filters.PublishedBefore.Unix()))
+		}
+		conditions = append(conditions, qdrant.NewRange("published_at", dateRange))
+	}
+
+	var filter *qdrant.Filter
+	if len(conditions) > 0 {
+		filter = &qdrant.Filter{Must: conditions}
+	}
 
 	points, err := Client.GetPointsClient().Search(ctx, &qdrant.SearchPoints{
 		CollectionName: QDRANT_COLLECTION_NAME,
 		Vector:         vector.Embedding,
+		Filter:         filter,
 		WithPayload:    qdrant.NewWithPayload(true),
 		Limit:          15,
 	})
@@ -97,10 +145,48 @@ func SearchPoints(ctx context.Context, vector models.EmbeddingModel) (*[]models.
 			URL:         payload["url"].GetStringValue(),
 			Status:      int32(payload["status"].GetIntegerValue()),
 			Content:     payload["content"].GetStringValue(),
+			Markdown:    payload["markdown"].GetStringValue(),
 			Description: payload["description"].GetStringValue(),
+			Language:    payload["language"].GetStringValue(),
+			EntityType:  payload["entity_type"].GetStringValue(),
+			PublishedAt: payload["published_at"].GetIntegerValue(),
 		})
+		if data, ok := payloadValue(payload["structured_data"]).(map[string]any); ok {
+			pages[len(pages)-1].StructuredData = data
+		}
 	}
 
 	return &pages, nil
 
 }
+
+// payloadValue converts a nested payload value back to plain Go values
+func payloadValue(value *qdrant.Value) any {
+	if value == nil {
+		return nil
+	}
+
+	switch kind := value.GetKind().(type) {
+	case *qdrant.Value_StringValue:
+		return kind.StringValue
+	case *qdrant.Value_IntegerValue:
+		return kind.IntegerValue
+	case *qdrant.Value_DoubleValue:
+		return kind.DoubleValue
+	case *qdrant.Value_BoolValue:
+		return kind.BoolValue
+	case *qdrant.Value_StructValue:
+		result := make(map[string]any)
+		for key, field := range kind.StructValue.GetFields() {
+			result[key] = payloadValue(field)
+		}
+		return result
+	case *qdrant.Value_ListValue:
+		result := make([]any, 0, len(kind.ListValue.GetValues()))
+		for _, item := range kind.ListValue.GetValues() {
+			result = append(result, payloadValue(item))
+		}
+		return result
+	}
+	return nil
+}
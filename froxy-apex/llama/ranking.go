@@ -0,0 +1,44 @@
+package llama
+
+import (
+	"math"
+	"regexp"
+	"time"
+)
+
+const (
+	// added to the similarity of chunks written in the language of the query
+	queryLanguageBoost = 0.05
+
+	// newer pages get a small edge, it halves every recencyHalfLife
+	recencyBoost    = 0.03
+	recencyHalfLife = 365 * 24 * time.Hour
+	// queries asking for recent content weigh the date a lot more
+	freshQueryRecencyBoost = 0.1
+	freshQueryHalfLife     = 30 * 24 * time.Hour
+)
+
+// checked against the original query, the enhanced one always carries a date
+var freshnessIntent = regexp.MustCompile(`(?i)\b(latest|recent|recently|newest|today|yesterday|this (week|month|year)|current|news|breaking)\b|أحدث|جديد|اليوم|آخر`)
+
+// boostChunks adds the language and recency signals to the similarity scores
+func boostChunks(chunks []ScoredChunk, query, language string, now time.Time) {
+	weight, halfLife := recencyBoost, recencyHalfLife
+	if freshnessIntent.MatchString(query) {
+		weight, halfLife = freshQueryRecencyBoost, freshQueryHalfLife
+	}
+
+	for i := range chunks {
+		if language != "" && chunks[i].Language == language {
+			chunks[i].Score += queryLanguageBoost
+		}
+
+		if chunks[i].PublishedAt > 0 {
+			age := now.Sub(time.Unix(chunks[i].PublishedAt, 0))
+			if age < 0 {
+				age = 0
+			}
+			chunks[i].Score += float32(weight * math.Pow(0.5, float64(age)/float64(halfLife)))
+		}
+	}
+}
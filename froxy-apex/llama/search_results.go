@@ -75,17 +75,21 @@ type AnalysisData struct {
 }
 
 type ScoredChunk struct {
-	Text    string
-	URL     string
-	Score   float32
-	Favicon string
+	Text        string
+	URL         string
+	Score       float32
+	Favicon     string
+	Language    string
+	PublishedAt int64
 }
 
 type ChunkJob struct {
-	chunk   string
-	url     string
-	index   int
-	favicon string
+	chunk       string
+	url         string
+	index       int
+	favicon     string
+	language    string
+	publishedAt int64
 }
 
 type ChunkResult struct {
@@ -287,8 +291,12 @@ func SearchWebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	// Handle WebSocket messages
 	for {
 		var request struct {
-			Query string `json:"query"`
-			Type  string `json:"type,omitempty"` // Allow different message types
+			Query           string `json:"query"`
+			Type            string `json:"type,omitempty"`             // Allow different message types
+			Language        string `json:"language,omitempty"`         // Only search pages in this language
+			EntityType      string `json:"entity_type,omitempty"`      // Only search pages about this schema.org type (Product, Article...)
+			PublishedAfter  string `json:"published_after,omitempty"`  // YYYY-MM-DD or RFC 3339
+			PublishedBefore string `json:"published_before,omitempty"` // YYYY-MM-DD or RFC 3339
 		}
 
 		// Reset read deadline for each message
@@ -324,13 +332,32 @@ func SearchWebSocketHandler(w http.ResponseWriter, r *http.Request) {
 
 		log.Printf("Received search query: %s", request.Query)
 
+		filters := models.SearchFilters{
+			Language:   utils.NormalizeLanguage(request.Language),
+			EntityType: request.EntityType,
+		}
+		var dateErr error
+		if request.PublishedAfter != "" {
+			filters.PublishedAfter, dateErr = utils.ParseDate(request.PublishedAfter)
+		}
+		if dateErr == nil && request.PublishedBefore != "" {
+			filters.PublishedBefore, dateErr = utils.ParseDateEnd(request.PublishedBefore)
+		}
+		if dateErr != nil {
+			if err := wsConn.SendMessage(MSG_ERROR, fmt.Sprintf("Invalid date filter: %v", dateErr), nil, 0); err != nil {
+				log.Printf("Failed to send error message: %v", err)
+				break
+			}
+			continue
+		}
+
 		// Set processing flag
 		wsConn.mutex.Lock()
 		wsConn.processing = true
 		wsConn.mutex.Unlock()
 
 		// Process search request
-		processSearchRequest(wsConn, request.Query)
+		processSearchRequest(wsConn, request.Query, filters)
 
 		// Clear processing flag
 		wsConn.mutex.Lock()
@@ -342,9 +369,15 @@ func SearchWebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func processSearchRequest(wsConn *WSConnection, query string) {
+func processSearchRequest(wsConn *WSConnection, query string, filters models.SearchFilters) {
 	start := time.Now()
 
+	// Without an explicit language filter, prefer results in the language the user wrote in
+	boostLanguage := ""
+	if filters.Language == "" {
+		boostLanguage = utils.DetectLanguage(query)
+	}
+
 	// Create context for entire process
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
@@ -421,7 +454,7 @@ func processSearchRequest(wsConn *WSConnection, query string) {
 	}, 1)
 
 	go func() {
-		points, err := db.SearchPoints(ctx, *queryEmbedding)
+		points, err := db.SearchPoints(ctx, *queryEmbedding, filters)
 		searchDone <- struct {
 			points *[]models.PagePoint
 			err    error
@@ -495,6 +528,8 @@ searchComplete:
 		return
 	}
 
+	boostChunks(chunks, query, boostLanguage, time.Now())
+
 	// Sort by relevance
 	sort.Slice(chunks, func(i, j int) bool {
 		return chunks[i].Score > chunks[j].Score
@@ -527,10 +562,24 @@ searchComplete:
 		}
 	}
 
-	// Build response
+	// Build response, the structured data of a source is given once, with its first chunk
+	structuredData := structuredDataByURL(*points)
+	usedStructuredData := make(map[string]map[string]any)
 	var chunkTexts []string
 	for _, c := range chunks {
-		chunkTexts = append(chunkTexts, fmt.Sprintf("From %s, Favicon %s, :\n%s", c.URL, c.Favicon, c.Text))
+		text := c.Text
+		if data, ok := structuredData[c.URL]; ok {
+			if _, seen := usedStructuredData[c.URL]; !seen {
+				usedStructuredData[c.URL] = data
+				if summary := structuredSummary(data); summary != "" {
+					text = "Structured data: " + summary + "\n" + text
+				}
+			}
+		}
+		if c.PublishedAt > 0 {
+			text = "Published: " + time.Unix(c.PublishedAt, 0).UTC().Format("2006-01-02") + "\n" + text
+		}
+		chunkTexts = append(chunkTexts, fmt.Sprintf("From %s, Favicon %s, :\n%s", c.URL, c.Favicon, text))
 	}
 	dataSummary := strings.Join(chunkTexts, "\n\n")
 
@@ -553,12 +602,15 @@ searchComplete:
 		ChunksUsed     int         `json:"chunks_used"`
 		SourcesCount   int         `json:"sources_count"`
 		SearchComplete bool        `json:"search_complete"`
+		// schema.org data of the sources, keyed by url, so the client can render rich results
+		StructuredData map[string]map[string]any `json:"structured_data,omitempty"`
 	}{
 		Response:       response,
 		TotalTime:      time.Since(start).String(),
 		ChunksUsed:     len(chunks),
 		SourcesCount:   len(getUniqueURLs(chunks)),
 		SearchComplete: true,
+		StructuredData: usedStructuredData,
 	}
 
 	// Send final response with retry logic
@@ -600,16 +652,24 @@ func processChunksWithProgress(wsConn *WSConnection, ctx context.Context, points
 			continue
 		}
 
-		chunked := chunkTextAggressive(point.Content, 1500, 100)
+		// the markdown keeps tables, lists and code blocks readable for the model
+		content := point.Content
+		if point.Markdown != "" {
+			content = point.Markdown
+		}
+
+		chunked := chunkByParagraphs(content, 1500, 100)
 		totalInitialChunks += len(chunked)
 
 		for i, chunk := range chunked {
 			if isHighQualityChunk(chunk) {
 				filteredChunks = append(filteredChunks, ChunkJob{
-					chunk:   chunk,
-					url:     point.URL,
-					index:   i,
-					favicon: point.Favicon,
+					chunk:       chunk,
+					url:         point.URL,
+					index:       i,
+					favicon:     point.Favicon,
+					language:    point.Language,
+					publishedAt: point.PublishedAt,
 				})
 			}
 		}
@@ -735,10 +795,12 @@ func fastChunkWorker(ctx context.Context, jobs <-chan ChunkJob, results chan<- C
 			score := utils.CosineSimilarity(embedding, queryEmbedding)
 			results <- ChunkResult{
 				chunk: ScoredChunk{
-					Text:    job.chunk,
-					URL:     job.url,
-					Score:   score,
-					Favicon: job.favicon,
+					Text:        job.chunk,
+					URL:         job.url,
+					Score:       score,
+					Favicon:     job.favicon,
+					Language:    job.language,
+					PublishedAt: job.publishedAt,
 				},
 				index: job.index,
 			}
@@ -876,6 +938,111 @@ func chunkTextAggressive(text string, maxLen int, overlap int) []string {
 	return chunks
 }
 
+// chunkByParagraphs packs whole paragraphs (separated by blank lines by the spider) into chunks,
+// only paragraphs longer than maxLen are cut with chunkTextAggressive, code fences are cut on line boundaries
+func chunkByParagraphs(text string, maxLen int, overlap int) []string {
+	if len(text) <= maxLen {
+		return []string{text}
+	}
+
+	var chunks []string
+	var current strings.Builder
+
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, paragraph := range splitParagraphs(text) {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+
+		if len(paragraph) > maxLen {
+			flush()
+			if strings.HasPrefix(paragraph, "```") {
+				chunks = append(chunks, chunkCodeBlock(paragraph, maxLen)...)
+			} else {
+				chunks = append(chunks, chunkTextAggressive(paragraph, maxLen, overlap)...)
+			}
+			continue
+		}
+
+		if current.Len() > 0 && current.Len()+2+len(paragraph) > maxLen {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(paragraph)
+	}
+	flush()
+
+	return chunks
+}
+
+// splitParagraphs splits on blank lines, except inside markdown code fences
+func splitParagraphs(text string) []string {
+	var paragraphs []string
+	var current []string
+	inFence := false
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+		if !inFence && strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				paragraphs = append(paragraphs, strings.Join(current, "\n"))
+				current = nil
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		paragraphs = append(paragraphs, strings.Join(current, "\n"))
+	}
+
+	return paragraphs
+}
+
+// chunkCodeBlock cuts a long fenced code block between lines and fences every piece again
+func chunkCodeBlock(block string, maxLen int) []string {
+	lines := strings.Split(block, "\n")
+	fence := lines[0]
+	body := lines[1:]
+	if len(body) > 0 && strings.TrimSpace(body[len(body)-1]) == "```" {
+		body = body[:len(body)-1]
+	}
+
+	var chunks []string
+	var current []string
+	size := 0
+
+	flush := func() {
+		if len(current) > 0 {
+			chunks = append(chunks, fence+"\n"+strings.Join(current, "\n")+"\n```")
+			current = nil
+			size = 0
+		}
+	}
+
+	for _, line := range body {
+		if len(current) > 0 && size+len(line)+len(fence)+5 > maxLen {
+			flush()
+		}
+		current = append(current, line)
+		size += len(line) + 1
+	}
+	flush()
+
+	return chunks
+}
+
 func isHighQualityChunk(chunk string) bool {
 	trimmed := strings.TrimSpace(chunk)
 
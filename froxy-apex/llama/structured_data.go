@@ -0,0 +1,98 @@
+package llama
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MultiX0/froxy/models"
+)
+
+// how many FAQ entries of a page are handed to the model
+const maxFAQInSummary = 3
+
+func structuredDataByURL(points []models.PagePoint) map[string]map[string]any {
+	result := make(map[string]map[string]any)
+	for _, point := range points {
+		if len(point.StructuredData) > 0 {
+			result[point.URL] = point.StructuredData
+		}
+	}
+	return result
+}
+
+// structuredSummary renders the structured data of a page as one compact line for the model
+func structuredSummary(data map[string]any) string {
+	parts := make([]string, 0)
+	add := func(label string, value string) {
+		if value != "" {
+			parts = append(parts, label+": "+value)
+		}
+	}
+
+	add("Type", stringField(data, "type"))
+	add("Name", stringField(data, "name"))
+	add("Authors", strings.Join(stringList(data["authors"]), ", "))
+	add("Published", stringField(data, "date_published"))
+	add("Modified", stringField(data, "date_modified"))
+
+	if product, ok := data["product"].(map[string]any); ok {
+		add("Brand", stringField(product, "brand"))
+		add("Price", strings.TrimSpace(stringField(product, "price")+" "+stringField(product, "currency")))
+		add("Availability", stringField(product, "availability"))
+		if rating := stringField(product, "rating"); rating != "" {
+			if reviews := stringField(product, "review_count"); reviews != "" {
+				rating += " (" + reviews + " reviews)"
+			}
+			add("Rating", rating)
+		}
+	}
+
+	if breadcrumb, ok := data["breadcrumb"].([]any); ok {
+		names := make([]string, 0, len(breadcrumb))
+		for _, item := range breadcrumb {
+			if crumb, ok := item.(map[string]any); ok {
+				names = append(names, stringField(crumb, "name"))
+			}
+		}
+		add("Section", strings.Join(names, " > "))
+	}
+
+	if faq, ok := data["faq"].([]any); ok {
+		for i, item := range faq {
+			if i == maxFAQInSummary {
+				break
+			}
+			if entry, ok := item.(map[string]any); ok {
+				add("Q", stringField(entry, "question")+" A: "+stringField(entry, "answer"))
+			}
+		}
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+func stringField(data map[string]any, key string) string {
+	switch value := data[key].(type) {
+	case string:
+		return value
+	case int64:
+		return fmt.Sprint(value)
+	case float64:
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+func stringList(value any) []string {
+	items, ok := value.([]any)
+	if !ok {
+		return nil
+	}
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok && s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
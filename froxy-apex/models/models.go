@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // I used groq.com api for (ai model)
 
 type PrompEnhancerResponse struct {
@@ -72,7 +74,22 @@ type PagePoint struct {
 	URL         string `json:"url"`
 	Status      int32  `json:"status"`
 	Content     string `json:"content"`
+	Markdown    string `json:"markdown"`
 	Description string `json:"description"`
+	Language    string `json:"language"`
+	EntityType  string `json:"entity_type"`
+	PublishedAt int64  `json:"published_at"` // unix seconds, 0 when the page has no date
+
+	// schema.org data of the page (type, authors, product, breadcrumb, faq...) as stored by the spider
+	StructuredData map[string]any `json:"structured_data,omitempty"`
+}
+
+// SearchFilters narrows the vector search, empty fields are not applied
+type SearchFilters struct {
+	Language        string
+	EntityType      string
+	PublishedAfter  time.Time
+	PublishedBefore time.Time
 }
 
 type EmbeddingModel struct {
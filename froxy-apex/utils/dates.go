@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParseDate reads the dates clients send in search filters
+func ParseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a YYYY-MM-DD or RFC 3339 date", value)
+}
+
+// ParseDateEnd reads the upper bound of a date filter, a day without a time covers the whole day
+func ParseDateEnd(value string) (time.Time, error) {
+	parsed, err := ParseDate(value)
+	if err != nil {
+		return parsed, err
+	}
+	if _, err := time.Parse("2006-01-02", strings.TrimSpace(value)); err == nil {
+		// published_at is stored in whole seconds
+		return parsed.AddDate(0, 0, 1).Add(-time.Second), nil
+	}
+	return parsed, nil
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/abadojack/whatlanggo"
+)
+
+// DetectLanguage returns the ISO 639-1 code of the text, or an empty string when the
+// text is too short to tell (most one or two word queries)
+func DetectLanguage(text string) string {
+	info := whatlanggo.Detect(text)
+	if !info.IsReliable() {
+		return ""
+	}
+	return info.Lang.Iso6391()
+}
+
+// NormalizeLanguage turns tags like "en-US", "en_GB" or "AR" into the two letter code the spider stores
+func NormalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if len(tag) != 2 {
+		return ""
+	}
+	return tag
+}
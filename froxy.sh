@@ -10,7 +10,7 @@ GRAY='\033[90m'
 RESET='\033[0m'
 
 NEEDS_CLEANUP=false
-GO_BACKUP_EXISTS=false
+SEEDS_FILE=""
 # Store the project root directory
 PROJECT_ROOT="$(pwd)"
 
@@ -331,13 +331,9 @@ cleanup_files() {
     if [ "$NEEDS_CLEANUP" = true ]; then
         echo -e "\n${YELLOW}Cleaning up...${RESET}"
         
-        GO_FILE="${PROJECT_ROOT}/spider/main.go"
-        GO_BACKUP="${PROJECT_ROOT}/spider/main_backup.go"
-        if [ "$GO_BACKUP_EXISTS" = true ] && [ -f "$GO_BACKUP" ]; then
-            rm -f "$GO_FILE" || echo -e "${RED}Failed to remove main.go${RESET}"
-            mv "$GO_BACKUP" "$GO_FILE" || echo -e "${RED}Failed to restore main_backup.go to main.go${RESET}"
-            echo -e "${GREEN}✓ Restored spider/main.go${RESET}"
-            GO_BACKUP_EXISTS=false
+        if [ -n "$SEEDS_FILE" ] && [ -f "$SEEDS_FILE" ]; then
+            rm -f "$SEEDS_FILE" || echo -e "${RED}Failed to remove the seeds file${RESET}"
+            SEEDS_FILE=""
         fi
         
         echo -e "${GREEN}✓ Cleanup completed${RESET}"
@@ -553,11 +549,7 @@ start_crawling() {
             break
         fi
 
-        if [ $url_count -eq 0 ]; then
-            url_list="\"$url\""
-        else
-            url_list="$url_list,\n\t\t\"$url\""
-        fi
+        url_list="${url_list}${url}\n"
         
         ((url_count++))
     done
@@ -590,75 +582,17 @@ start_crawling() {
     echo -e ""
     echo -e "${GREEN}Starting crawler with $url_count URLs and $workers workers...${RESET}"
 
-    cat > "${PROJECT_ROOT}/spider/main_temp.go" << EOF
-package main
-
-import (
-	"fmt"
-	"log"
-	"time"
-
-	"github.com/froxy/db"
-	"github.com/froxy/functions"
-	"github.com/joho/godotenv"
-)
-
-func main() {
-	fmt.Println("starting spider bot")
-	err := godotenv.Load()
-	if err != nil {
-		log.Panic(err)
-		return
-	}
-
-	err = db.InitQdrant()
-	if err != nil {
-		log.Panic(err)
-		return
-	}
-
-	err = db.InitPostgres(db.Client)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	defer db.GetPostgresHandler().GracefulShutdown(time.Second * 5)
-
-	crawler := functions.NewCrawler()
-
-	var crawlableSites = []string{
-		$(echo -e "$url_list"),
-	}
-
-	crawler.Start(
-		$workers, // the number of the worker
-		crawlableSites...,
-	)
-}
-EOF
-
-    echo -e "${GREEN}Updating spider/main.go...${RESET}"
-    GO_FILE="${PROJECT_ROOT}/spider/main.go"
-    GO_BACKUP="${PROJECT_ROOT}/spider/main_backup.go"
-    if [ -f "$GO_FILE" ]; then
-        cp "$GO_FILE" "$GO_BACKUP" || {
-            echo -e "${RED}Failed to back up main.go${RESET}"
-            return 1
-        }
-        GO_BACKUP_EXISTS=true
-        NEEDS_CLEANUP=true
-        echo -e "${GREEN}✓ Backed up original main.go${RESET}"
-    fi
-    mv "${PROJECT_ROOT}/spider/main_temp.go" "$GO_FILE" || {
-        echo -e "${RED}Failed to update main.go${RESET}"
+    SEEDS_FILE="${PROJECT_ROOT}/spider/seeds_temp.txt"
+    echo -ne "$url_list" > "$SEEDS_FILE" || {
+        echo -e "${RED}Failed to write the seed urls${RESET}"
         return 1
     }
+    NEEDS_CLEANUP=true
 
     echo -e "${GREEN}Running crawler...${RESET}"
     echo -e "${GRAY}──────────────────────────────${RESET}"
     cd "${PROJECT_ROOT}/spider"
-    go run main.go &
+    go run . crawl -workers "$workers" -seeds-file "$SEEDS_FILE" &
     CRAWLER_PID=$!
     wait $CRAWLER_PID
     CRAWLER_EXIT_CODE=$?
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/froxy/models"
+	"github.com/qdrant/go-client/qdrant"
+)
+
+// RecordAliases marks the urls as aliases of the canonical url, for pages that are not stored
+// themselves (e.g. a meta refresh to another page)
+func (p *PostgresHandler) RecordAliases(canonical string, aliases []models.URLAlias) error {
+	if p == nil || p.db == nil {
+		return fmt.Errorf("database handler or connection is nil")
+	}
+	if len(aliases) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	err := p.withTransaction(ctx, func(tx *sql.Tx) error {
+		return p.recordAliases(ctx, tx, canonical, aliases)
+	})
+	if err != nil {
+		return err
+	}
+
+	return deleteAliasPoints(p.qdrantClient, aliases)
+}
+
+// recordAliases upserts the aliases of a page and drops the pages stored under them,
+// the stored page itself is never an alias
+func (p *PostgresHandler) recordAliases(ctx context.Context, tx *sql.Tx, canonical string, aliases []models.URLAlias) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM url_aliases WHERE url = $1", canonical); err != nil {
+		return fmt.Errorf("failed to clear alias of canonical url: %w", err)
+	}
+
+	query := `
+		INSERT INTO url_aliases (url, canonical_url, reason)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (url) DO UPDATE SET
+			canonical_url = EXCLUDED.canonical_url,
+			reason = EXCLUDED.reason,
+			updated_at = CURRENT_TIMESTAMP;`
+
+	for _, alias := range aliases {
+		if alias.URL == "" || alias.URL == canonical {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, query, alias.URL, canonical, alias.Reason); err != nil {
+			return fmt.Errorf("failed to record url alias: %w", err)
+		}
+		// links of the old page go with it (ON DELETE CASCADE)
+		if _, err := tx.ExecContext(ctx, "DELETE FROM pages WHERE url = $1", alias.URL); err != nil {
+			return fmt.Errorf("failed to delete aliased page: %w", err)
+		}
+	}
+	return nil
+}
+
+// deleteAliasPoints removes the Qdrant points an alias may have from before it was known as one
+func deleteAliasPoints(client *qdrant.Client, aliases []models.URLAlias) error {
+	for _, alias := range aliases {
+		if err := DeletePageFromQdrant(client, alias.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -127,6 +128,30 @@ func (p *PostgresHandler) createTables() error {
 		url TEXT NOT NULL UNIQUE,
 		title TEXT,
 		status_code INTEGER,
+		favicon TEXT,
+		etag TEXT,
+		last_modified TIMESTAMP WITHOUT TIME ZONE,
+		content_hash CHARACTER(64),
+		recrawl_interval INTEGER,
+		last_checked_at TIMESTAMP WITHOUT TIME ZONE,
+		next_crawl_at TIMESTAMP WITHOUT TIME ZONE,
+		simhash BIGINT,
+		simhash_b0 INTEGER,
+		simhash_b1 INTEGER,
+		simhash_b2 INTEGER,
+		simhash_b3 INTEGER,
+		duplicate_of TEXT,
+		language CHARACTER VARYING(8),
+		encoding CHARACTER VARYING(40),
+		content_type TEXT,
+		page_count INTEGER,
+		entity_type CHARACTER VARYING(64),
+		structured_data JSONB,
+		published_at TIMESTAMP WITHOUT TIME ZONE,
+		modified_at TIMESTAMP WITHOUT TIME ZONE,
+		authors TEXT[],
+		redirect_chain TEXT[],
+		ttfb_ms INTEGER,
 		crawl_date TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
 	);`
@@ -142,17 +167,147 @@ func (p *PostgresHandler) createTables() error {
 		created_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
 	);`
 
+	// Crawl frontier so a stopped crawl can resume where it left off
+	createFrontierTable := `
+	CREATE TABLE IF NOT EXISTS crawl_frontier (
+		id BIGSERIAL PRIMARY KEY,
+		url TEXT NOT NULL UNIQUE,
+		anchor_text TEXT,
+		status CHARACTER VARYING(20) NOT NULL DEFAULT 'queued',
+		priority REAL DEFAULT 0,
+		lastmod TIMESTAMP WITHOUT TIME ZONE,
+		change_freq CHARACTER VARYING(20),
+		depth INTEGER DEFAULT 0,
+		inlinks INTEGER DEFAULT 0,
+		score REAL DEFAULT 0,
+		relevance REAL DEFAULT 0,
+		enqueued_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Other urls of a stored page (redirect sources, non canonical copies)
+	createAliasesTable := `
+	CREATE TABLE IF NOT EXISTS url_aliases (
+		url TEXT PRIMARY KEY,
+		canonical_url TEXT NOT NULL,
+		reason CHARACTER VARYING(20) NOT NULL,
+		created_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Fetches that failed, transient failures have a next_retry_at and are tried again later
+	createFailedTable := `
+	CREATE TABLE IF NOT EXISTS failed_urls (
+		url TEXT PRIMARY KEY,
+		anchor_text TEXT,
+		depth INTEGER DEFAULT 0,
+		outcome CHARACTER VARYING(20) NOT NULL,
+		status_code INTEGER,
+		error TEXT,
+		attempts INTEGER NOT NULL DEFAULT 1,
+		first_failed_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		last_failed_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		next_retry_at TIMESTAMP WITHOUT TIME ZONE
+	);`
+
+	// Every request for a page, successful or not, written in batches by the crawler
+	createAttemptsTable := `
+	CREATE TABLE IF NOT EXISTS fetch_attempts (
+		id BIGSERIAL PRIMARY KEY,
+		url TEXT NOT NULL,
+		host TEXT NOT NULL,
+		status_code INTEGER,
+		outcome CHARACTER VARYING(20) NOT NULL,
+		error TEXT,
+		response_time_ms INTEGER,
+		ttfb_ms INTEGER,
+		proxy TEXT,
+		attempted_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// URL patterns found to be crawler traps and how many of their urls were not queued
+	createTrapsTable := `
+	CREATE TABLE IF NOT EXISTS crawl_traps (
+		host TEXT NOT NULL,
+		pattern TEXT NOT NULL,
+		reason CHARACTER VARYING(20) NOT NULL,
+		blocked BOOLEAN NOT NULL DEFAULT FALSE,
+		suppressed INTEGER NOT NULL DEFAULT 0,
+		detected_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (host, pattern)
+	);`
+
+	// Columns added after the first release, tables created by older versions get them here
+	migrations := []string{
+		"ALTER TABLE crawl_frontier ADD COLUMN IF NOT EXISTS priority REAL DEFAULT 0;",
+		"ALTER TABLE crawl_frontier ADD COLUMN IF NOT EXISTS lastmod TIMESTAMP WITHOUT TIME ZONE;",
+		"ALTER TABLE crawl_frontier ADD COLUMN IF NOT EXISTS change_freq CHARACTER VARYING(20);",
+		"ALTER TABLE crawl_frontier ADD COLUMN IF NOT EXISTS depth INTEGER DEFAULT 0;",
+		"ALTER TABLE crawl_frontier ADD COLUMN IF NOT EXISTS inlinks INTEGER DEFAULT 0;",
+		"ALTER TABLE crawl_frontier ADD COLUMN IF NOT EXISTS score REAL DEFAULT 0;",
+		"ALTER TABLE crawl_frontier ADD COLUMN IF NOT EXISTS relevance REAL DEFAULT 0;",
+		"ALTER TABLE fetch_attempts ADD COLUMN IF NOT EXISTS ttfb_ms INTEGER;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS favicon TEXT;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS etag TEXT;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS last_modified TIMESTAMP WITHOUT TIME ZONE;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS content_hash CHARACTER(64);",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS recrawl_interval INTEGER;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS last_checked_at TIMESTAMP WITHOUT TIME ZONE;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS next_crawl_at TIMESTAMP WITHOUT TIME ZONE;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS simhash BIGINT;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS simhash_b0 INTEGER;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS simhash_b1 INTEGER;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS simhash_b2 INTEGER;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS simhash_b3 INTEGER;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS duplicate_of TEXT;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS language CHARACTER VARYING(8);",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS encoding CHARACTER VARYING(40);",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS content_type TEXT;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS page_count INTEGER;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS entity_type CHARACTER VARYING(64);",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS structured_data JSONB;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS published_at TIMESTAMP WITHOUT TIME ZONE;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS modified_at TIMESTAMP WITHOUT TIME ZONE;",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS authors TEXT[];",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS redirect_chain TEXT[];",
+		"ALTER TABLE pages ADD COLUMN IF NOT EXISTS ttfb_ms INTEGER;",
+		// pages stored before recrawls existed are due one interval after their crawl, not all at once
+		`UPDATE pages SET next_crawl_at = COALESCE(crawl_date, CURRENT_TIMESTAMP) + (COALESCE(recrawl_interval, 86400) * INTERVAL '1 second')
+			WHERE next_crawl_at IS NULL;`,
+	}
+
 	// Create indexes
 	createIndexes := []string{
 		"CREATE INDEX IF NOT EXISTS idx_pages_url ON pages(url);",
 		"CREATE INDEX IF NOT EXISTS idx_pages_qdrant_id ON pages(qdrant_id);",
 		"CREATE INDEX IF NOT EXISTS idx_links_from_page_id ON links(from_page_id);",
 		"CREATE INDEX IF NOT EXISTS idx_links_to_url ON links(to_url);",
+		"CREATE INDEX IF NOT EXISTS idx_crawl_frontier_status ON crawl_frontier(status);",
+		"CREATE INDEX IF NOT EXISTS idx_crawl_frontier_status_score ON crawl_frontier(status, score DESC);",
+		"CREATE INDEX IF NOT EXISTS idx_pages_next_crawl_at ON pages(next_crawl_at);",
+		"CREATE INDEX IF NOT EXISTS idx_pages_simhash_b0 ON pages(simhash_b0);",
+		"CREATE INDEX IF NOT EXISTS idx_pages_simhash_b1 ON pages(simhash_b1);",
+		"CREATE INDEX IF NOT EXISTS idx_pages_simhash_b2 ON pages(simhash_b2);",
+		"CREATE INDEX IF NOT EXISTS idx_pages_simhash_b3 ON pages(simhash_b3);",
+		"CREATE INDEX IF NOT EXISTS idx_pages_duplicate_of ON pages(duplicate_of);",
+		"CREATE INDEX IF NOT EXISTS idx_pages_language ON pages(language);",
+		"CREATE INDEX IF NOT EXISTS idx_pages_entity_type ON pages(entity_type);",
+		"CREATE INDEX IF NOT EXISTS idx_pages_published_at ON pages(published_at);",
+		"CREATE INDEX IF NOT EXISTS idx_url_aliases_canonical_url ON url_aliases(canonical_url);",
+		"CREATE INDEX IF NOT EXISTS idx_failed_urls_next_retry_at ON failed_urls(next_retry_at);",
+		"CREATE INDEX IF NOT EXISTS idx_fetch_attempts_host_attempted_at ON fetch_attempts(host, attempted_at);",
+		"CREATE INDEX IF NOT EXISTS idx_fetch_attempts_attempted_at ON fetch_attempts(attempted_at);",
 	}
 
 	tables := []string{
 		createPagesTable,
 		createLinksTable,
+		createFrontierTable,
+		createAliasesTable,
+		createFailedTable,
+		createAttemptsTable,
+		createTrapsTable,
 	}
 
 	// Create tables
@@ -162,6 +317,13 @@ func (p *PostgresHandler) createTables() error {
 		}
 	}
 
+	// Add missing columns
+	for _, query := range migrations {
+		if _, err := p.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to migrate table: %v", err)
+		}
+	}
+
 	// Create indexes
 	for _, query := range createIndexes {
 		if _, err := p.db.ExecContext(ctx, query); err != nil {
@@ -224,18 +386,61 @@ func (p *PostgresHandler) UpsertPageData(pageData models.PageData) error {
 
 	// Generate deterministic UUID for Qdrant
 	qdrantID := utils.GenerateUUIDFromURL(pageData.URL)
+	bands := utils.SimHashBands(pageData.SimHash)
+
+	// NULL rather than an empty object for pages without structured data
+	var structuredData any
+	if !pageData.StructuredData.IsEmpty() {
+		encoded, err := json.Marshal(pageData.StructuredData)
+		if err != nil {
+			return fmt.Errorf("failed to encode structured data: %w", err)
+		}
+		structuredData = string(encoded)
+	}
 
 	var pageID int
 	err := p.withTransaction(ctx, func(tx *sql.Tx) error {
 		// Upsert page data
 		upsertPageQuery := `
 			INSERT INTO pages (
-				qdrant_id, url, title, status_code, crawl_date, updated_at, favicon
-			) VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, $6)
+				qdrant_id, url, title, status_code, crawl_date, updated_at, favicon,
+				etag, last_modified, content_hash, recrawl_interval, last_checked_at, next_crawl_at,
+				simhash, simhash_b0, simhash_b1, simhash_b2, simhash_b3, duplicate_of, language, encoding,
+				content_type, page_count, entity_type, structured_data,
+				published_at, modified_at, authors, redirect_chain, ttfb_ms
+			) VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, $6,
+				$7, $8, $9, $10, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + ($10 * INTERVAL '1 second'),
+				$11, $12, $13, $14, $15, NULLIF($16, ''), NULLIF($17, ''), NULLIF($18, ''),
+				$19, NULLIF($20, 0), NULLIF($21, ''), $22,
+				$23, $24, $25, $26, NULLIF($27, 0))
 			ON CONFLICT (url) DO UPDATE SET
 				title = EXCLUDED.title,
 				status_code = EXCLUDED.status_code,
 				crawl_date = EXCLUDED.crawl_date,
+				favicon = EXCLUDED.favicon,
+				etag = EXCLUDED.etag,
+				last_modified = EXCLUDED.last_modified,
+				content_hash = EXCLUDED.content_hash,
+				recrawl_interval = EXCLUDED.recrawl_interval,
+				last_checked_at = EXCLUDED.last_checked_at,
+				next_crawl_at = EXCLUDED.next_crawl_at,
+				simhash = EXCLUDED.simhash,
+				simhash_b0 = EXCLUDED.simhash_b0,
+				simhash_b1 = EXCLUDED.simhash_b1,
+				simhash_b2 = EXCLUDED.simhash_b2,
+				simhash_b3 = EXCLUDED.simhash_b3,
+				duplicate_of = EXCLUDED.duplicate_of,
+				language = EXCLUDED.language,
+				encoding = EXCLUDED.encoding,
+				content_type = EXCLUDED.content_type,
+				page_count = EXCLUDED.page_count,
+				entity_type = EXCLUDED.entity_type,
+				structured_data = EXCLUDED.structured_data,
+				published_at = EXCLUDED.published_at,
+				modified_at = EXCLUDED.modified_at,
+				authors = EXCLUDED.authors,
+				redirect_chain = EXCLUDED.redirect_chain,
+				ttfb_ms = EXCLUDED.ttfb_ms,
 				updated_at = CURRENT_TIMESTAMP
 			RETURNING id;`
 
@@ -246,6 +451,27 @@ func (p *PostgresHandler) UpsertPageData(pageData models.PageData) error {
 			pageData.StatusCode,
 			pageData.CrawlDate,
 			pageData.Favicon,
+			pageData.ETag,
+			nullTime(pageData.LastModified),
+			pageData.ContentHash,
+			int64(pageData.RecrawlInterval/time.Second),
+			int64(pageData.SimHash),
+			bands[0],
+			bands[1],
+			bands[2],
+			bands[3],
+			pageData.DuplicateOf,
+			pageData.Language,
+			pageData.Encoding,
+			pageData.ContentType,
+			pageData.PageCount,
+			pageData.StructuredData.Type,
+			structuredData,
+			nullTime(pageData.PublishedAt),
+			nullTime(pageData.ModifiedAt),
+			pq.Array(pageData.Authors),
+			pq.Array(pageData.RedirectChain),
+			pageData.TimeToFirstByte.Milliseconds(),
 		).Scan(&pageID)
 
 		if err != nil {
@@ -265,13 +491,30 @@ func (p *PostgresHandler) UpsertPageData(pageData models.PageData) error {
 			return fmt.Errorf("failed to insert links: %w", err)
 		}
 
-		return nil
+		return p.recordAliases(ctx, tx, pageData.URL, pageData.Aliases)
 	})
 
 	if err != nil {
 		return fmt.Errorf("failed to upsert page to PostgreSQL: %w", err)
 	}
 
+	// Pages stored under an alias by earlier crawls lose their point
+	if err := deleteAliasPoints(p.qdrantClient, pageData.Aliases); err != nil {
+		log.Printf("ERROR: Failed to delete alias points from Qdrant for URL %s: %v", pageData.URL, err)
+		return fmt.Errorf("failed to delete alias points from Qdrant: %w", err)
+	}
+
+	// Near-duplicates are only tracked in PostgreSQL, the canonical page already has a point
+	if pageData.DuplicateOf != "" {
+		if err := DeletePageFromQdrant(p.qdrantClient, pageData.URL); err != nil {
+			log.Printf("ERROR: Failed to delete duplicate page from Qdrant for URL %s: %v", pageData.URL, err)
+			return fmt.Errorf("failed to delete duplicate page from Qdrant: %w", err)
+		}
+
+		log.Printf("Stored %s as a near-duplicate of %s (PostgreSQL ID: %d)", pageData.URL, pageData.DuplicateOf, pageID)
+		return nil
+	}
+
 	// upsert to Qdrant
 	if err := UpsertPageToQdrant(p.qdrantClient, pageData); err != nil {
 		log.Printf("ERROR: Failed to upsert page to Qdrant for URL %s: %v", pageData.URL, err)
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/froxy/utils"
+)
+
+// FindNearDuplicate returns the url of an indexed page whose SimHash is within maxDistance bits
+// of the fingerprint, or an empty string when the page is not a near-duplicate.
+func (p *PostgresHandler) FindNearDuplicate(url string, fingerprint uint64, maxDistance int) (string, error) {
+	if p == nil || p.db == nil {
+		return "", fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	bands := utils.SimHashBands(fingerprint)
+	query := `
+		SELECT url, simhash FROM pages
+		WHERE url <> $1
+			AND duplicate_of IS NULL
+			AND simhash IS NOT NULL
+			AND (simhash_b0 = $2 OR simhash_b1 = $3 OR simhash_b2 = $4 OR simhash_b3 = $5)
+		ORDER BY crawl_date
+		LIMIT 200`
+
+	rows, err := p.db.QueryContext(ctx, query, url, bands[0], bands[1], bands[2], bands[3])
+	if err != nil {
+		return "", fmt.Errorf("failed to query near-duplicate candidates: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var candidateURL string
+		var candidate int64
+		if err := rows.Scan(&candidateURL, &candidate); err != nil {
+			return "", fmt.Errorf("failed to scan near-duplicate candidate: %w", err)
+		}
+		if utils.HammingDistance(fingerprint, uint64(candidate)) <= maxDistance {
+			return candidateURL, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("error iterating near-duplicate candidates: %w", err)
+	}
+
+	return "", nil
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/froxy/models"
+)
+
+// RecordFailedURL upserts a failed fetch and schedules its next pass following the policy.
+// It returns when the url will be tried again, the zero time when it is given up.
+func (p *PostgresHandler) RecordFailedURL(failure models.FailedURL, policy models.RetryPolicy) (time.Time, error) {
+	if p == nil || p.db == nil {
+		return time.Time{}, fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// attempts counts the failures, the wait doubles with each of them and never goes below Retry-After
+	query := `
+		INSERT INTO failed_urls (url, anchor_text, depth, outcome, status_code, error, next_retry_at)
+		VALUES ($1, $2, $3, $4, $5, $6,
+			CASE WHEN $7 AND 1 < $10 THEN
+				CURRENT_TIMESTAMP + make_interval(secs => LEAST(GREATEST($8::float8, $9::float8), $11::float8))
+			END)
+		ON CONFLICT (url) DO UPDATE SET
+			anchor_text = EXCLUDED.anchor_text,
+			depth = EXCLUDED.depth,
+			outcome = EXCLUDED.outcome,
+			status_code = EXCLUDED.status_code,
+			error = EXCLUDED.error,
+			attempts = failed_urls.attempts + 1,
+			last_failed_at = CURRENT_TIMESTAMP,
+			next_retry_at = CASE WHEN $7 AND failed_urls.attempts + 1 < $10 THEN
+				CURRENT_TIMESTAMP + make_interval(secs => LEAST(GREATEST($8::float8, $9::float8 * power(2, failed_urls.attempts)), $11::float8))
+			END
+		RETURNING next_retry_at;`
+
+	var nextRetry sql.NullTime
+	err := p.db.QueryRowContext(ctx, query,
+		failure.Link.URL,
+		failure.Link.Text,
+		failure.Link.Depth,
+		failure.Outcome,
+		sql.NullInt64{Int64: int64(failure.StatusCode), Valid: failure.StatusCode != 0},
+		failure.Error,
+		failure.Retry,
+		failure.RetryAfter.Seconds(),
+		policy.BaseDelay.Seconds(),
+		policy.MaxAttempts,
+		policy.MaxDelay.Seconds(),
+	).Scan(&nextRetry)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to record failed url: %w", err)
+	}
+	return nextRetry.Time, nil
+}
+
+// ClearFailedURL forgets the failures of a url that was fetched again
+func (p *PostgresHandler) ClearFailedURL(url string) error {
+	if p == nil || p.db == nil {
+		return fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := p.db.ExecContext(ctx, "DELETE FROM failed_urls WHERE url = $1", url); err != nil {
+		return fmt.Errorf("failed to clear failed url: %w", err)
+	}
+	return nil
+}
+
+// DueFailedURLs returns the failed urls whose next pass is due, the oldest first
+func (p *PostgresHandler) DueFailedURLs(limit int) ([]models.Link, error) {
+	if p == nil || p.db == nil {
+		return nil, fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT url, COALESCE(anchor_text, ''), COALESCE(depth, 0)
+		FROM failed_urls
+		WHERE next_retry_at <= CURRENT_TIMESTAMP
+		ORDER BY next_retry_at
+		LIMIT $1`
+
+	rows, err := p.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get due failed urls: %w", err)
+	}
+	defer rows.Close()
+
+	links := make([]models.Link, 0)
+	for rows.Next() {
+		var link models.Link
+		if err := rows.Scan(&link.URL, &link.Text, &link.Depth); err != nil {
+			return nil, fmt.Errorf("failed to scan failed url: %w", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating failed urls: %w", err)
+	}
+
+	return links, nil
+}
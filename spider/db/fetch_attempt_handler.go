@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/froxy/models"
+	"github.com/lib/pq"
+)
+
+// RecordFetchAttempts writes a batch of attempts with a single COPY
+func (p *PostgresHandler) RecordFetchAttempts(attempts []models.FetchAttempt) error {
+	if p == nil || p.db == nil {
+		return fmt.Errorf("database handler or connection is nil")
+	}
+	if len(attempts) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return p.withTransaction(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, pq.CopyIn("fetch_attempts",
+			"url", "host", "status_code", "outcome", "error", "response_time_ms", "ttfb_ms", "proxy", "attempted_at"))
+		if err != nil {
+			return fmt.Errorf("failed to prepare fetch attempts copy: %w", err)
+		}
+		defer stmt.Close()
+
+		for _, attempt := range attempts {
+			var statusCode, errorText, ttfb, proxy any
+			if attempt.StatusCode != 0 {
+				statusCode = attempt.StatusCode
+			}
+			if attempt.TimeToFirstByte != 0 {
+				ttfb = attempt.TimeToFirstByte.Milliseconds()
+			}
+			if attempt.Error != "" {
+				errorText = attempt.Error
+			}
+			if attempt.Proxy != "" {
+				proxy = attempt.Proxy
+			}
+
+			_, err := stmt.ExecContext(ctx, attempt.URL, attempt.Host, statusCode, attempt.Outcome, errorText,
+				attempt.ResponseTime.Milliseconds(), ttfb, proxy, attempt.AttemptedAt)
+			if err != nil {
+				return fmt.Errorf("failed to copy fetch attempt: %w", err)
+			}
+		}
+
+		// the empty exec flushes the COPY buffer
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			return fmt.Errorf("failed to write fetch attempts: %w", err)
+		}
+		return nil
+	})
+}
+
+// HostErrorSummary counts the failed attempts since the given time per host and outcome, the biggest first.
+// An empty host summarizes every host.
+func (p *PostgresHandler) HostErrorSummary(host string, since time.Time, limit int) ([]models.HostErrors, error) {
+	if p == nil || p.db == nil {
+		return nil, fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT host, outcome, COUNT(*), MAX(attempted_at)
+		FROM fetch_attempts
+		WHERE outcome <> $1 AND attempted_at >= $2 AND ($3::text = '' OR host = $3)
+		GROUP BY host, outcome
+		ORDER BY COUNT(*) DESC, host, outcome
+		LIMIT $4`
+
+	rows, err := p.db.QueryContext(ctx, query, models.OutcomeOK, since, host, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize fetch errors: %w", err)
+	}
+	defer rows.Close()
+
+	summary := make([]models.HostErrors, 0)
+	for rows.Next() {
+		var hostErrors models.HostErrors
+		if err := rows.Scan(&hostErrors.Host, &hostErrors.Outcome, &hostErrors.Count, &hostErrors.LastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan fetch error count: %w", err)
+		}
+		summary = append(summary, hostErrors)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating fetch error counts: %w", err)
+	}
+
+	return summary, nil
+}
+
+// FailedAttempts returns the latest failed attempts of a host since the given time
+func (p *PostgresHandler) FailedAttempts(host string, since time.Time, limit int) ([]models.FetchAttempt, error) {
+	if p == nil || p.db == nil {
+		return nil, fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT url, host, COALESCE(status_code, 0), outcome, COALESCE(error, ''),
+			COALESCE(response_time_ms, 0), COALESCE(proxy, ''), attempted_at
+		FROM fetch_attempts
+		WHERE host = $1 AND outcome <> $2 AND attempted_at >= $3
+		ORDER BY attempted_at DESC
+		LIMIT $4`
+
+	rows, err := p.db.QueryContext(ctx, query, host, models.OutcomeOK, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get failed attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := make([]models.FetchAttempt, 0)
+	for rows.Next() {
+		var attempt models.FetchAttempt
+		var responseTime int64
+		if err := rows.Scan(&attempt.URL, &attempt.Host, &attempt.StatusCode, &attempt.Outcome, &attempt.Error,
+			&responseTime, &attempt.Proxy, &attempt.AttemptedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan failed attempt: %w", err)
+		}
+		attempt.ResponseTime = time.Duration(responseTime) * time.Millisecond
+		attempts = append(attempts, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating failed attempts: %w", err)
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/froxy/models"
+)
+
+// Frontier statuses stored in the crawl_frontier table.
+// A URL is "queued" when discovered, "in_progress" once a worker dequeues it
+// and "done" after the worker finished with it. A fetch that failed for a reason
+// that may go away is "failed" until its next pass (see failed_urls).
+// Links past the in-memory window of the crawler are "spilled" until there is room for them.
+const (
+	FrontierQueued     = "queued"
+	FrontierInProgress = "in_progress"
+	FrontierDone       = "done"
+	FrontierFailed     = "failed"
+	FrontierSpilled    = "spilled"
+)
+
+// EnqueueFrontier persists a discovered link as queued, or as spilled when the crawler has no room for it.
+// A url already in the frontier only gets one more inlink, except a spilled one being queued.
+// It reports whether the url was new to the frontier.
+func (p *PostgresHandler) EnqueueFrontier(link models.Link, score float64, spill bool) (bool, error) {
+	if p == nil || p.db == nil {
+		return false, fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	status := FrontierQueued
+	if spill {
+		status = FrontierSpilled
+	}
+
+	// xmax is zero for a row this statement inserted
+	query := `
+		INSERT INTO crawl_frontier (url, anchor_text, status, priority, lastmod, change_freq, depth, inlinks, score, relevance)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (url) DO UPDATE SET
+			inlinks = crawl_frontier.inlinks + 1,
+			status = CASE WHEN crawl_frontier.status = $11 THEN EXCLUDED.status ELSE crawl_frontier.status END,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING xmax = 0;`
+
+	var inserted bool
+	err := p.db.QueryRowContext(ctx, query, link.URL, link.Text, status, link.Priority, nullTime(link.LastMod),
+		link.ChangeFreq, link.Depth, link.Inlinks, score, link.Relevance, FrontierSpilled).Scan(&inserted)
+	if err != nil {
+		return false, fmt.Errorf("failed to enqueue frontier url: %w", err)
+	}
+	return inserted, nil
+}
+
+// LoadSpilledFrontier moves the best spilled links back to queued and returns them
+func (p *PostgresHandler) LoadSpilledFrontier(limit int) ([]models.Link, error) {
+	if p == nil || p.db == nil {
+		return nil, fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE crawl_frontier
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM crawl_frontier
+			WHERE status = $2
+			ORDER BY score DESC, id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING url, COALESCE(anchor_text, ''), COALESCE(priority, 0), lastmod, COALESCE(change_freq, ''),
+			COALESCE(depth, 0), COALESCE(inlinks, 0), COALESCE(relevance, 0)`
+
+	rows, err := p.db.QueryContext(ctx, query, FrontierQueued, FrontierSpilled, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load spilled urls: %w", err)
+	}
+	defer rows.Close()
+
+	return scanFrontierLinks(rows)
+}
+
+// scanFrontierLinks reads the rows of a query selecting url, anchor_text, priority, lastmod, change_freq, depth, inlinks and relevance
+func scanFrontierLinks(rows *sql.Rows) ([]models.Link, error) {
+	links := make([]models.Link, 0)
+	for rows.Next() {
+		var link models.Link
+		var lastMod sql.NullTime
+		if err := rows.Scan(&link.URL, &link.Text, &link.Priority, &lastMod, &link.ChangeFreq, &link.Depth, &link.Inlinks, &link.Relevance); err != nil {
+			return nil, fmt.Errorf("failed to scan queued url: %w", err)
+		}
+		if lastMod.Valid {
+			link.LastMod = lastMod.Time
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating queued urls: %w", err)
+	}
+	return links, nil
+}
+
+// UpdateFrontierStatus moves a frontier url to the given status
+func (p *PostgresHandler) UpdateFrontierStatus(url, status string) error {
+	if p == nil || p.db == nil {
+		return fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO crawl_frontier (url, status)
+		VALUES ($1, $2)
+		ON CONFLICT (url) DO UPDATE SET
+			status = EXCLUDED.status,
+			updated_at = CURRENT_TIMESTAMP;`
+
+	if _, err := p.db.ExecContext(ctx, query, url, status); err != nil {
+		return fmt.Errorf("failed to update frontier status: %w", err)
+	}
+	return nil
+}
+
+// LoadFrontier returns the best pending links of a previous crawl, at most limit of them.
+// URLs that were dequeued but never finished (in_progress) are pending again, and the pending links
+// past the limit are marked spilled to be loaded as the frontier empties.
+func (p *PostgresHandler) LoadFrontier(limit int) ([]models.Link, error) {
+	if p == nil || p.db == nil {
+		return nil, fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	spill := `
+		UPDATE crawl_frontier
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE status IN ($2, $3);`
+
+	load := `
+		UPDATE crawl_frontier
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM crawl_frontier
+			WHERE status = $2
+			ORDER BY score DESC, id
+			LIMIT $3
+		)
+		RETURNING url, COALESCE(anchor_text, ''), COALESCE(priority, 0), lastmod, COALESCE(change_freq, ''),
+			COALESCE(depth, 0), COALESCE(inlinks, 0), COALESCE(relevance, 0)`
+
+	var links []models.Link
+	err := p.withTransaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, spill, FrontierSpilled, FrontierQueued, FrontierInProgress); err != nil {
+			return fmt.Errorf("failed to spill pending urls: %w", err)
+		}
+
+		rows, err := tx.QueryContext(ctx, load, FrontierQueued, FrontierSpilled, limit)
+		if err != nil {
+			return fmt.Errorf("failed to load queued urls: %w", err)
+		}
+		defer rows.Close()
+
+		links, err = scanFrontierLinks(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// LoadVisitedFrontier returns a page of the urls a previous crawl is done with, those after the id afterID.
+// Failed ones count as visited until they are due again. It also returns the id to pass for the next page,
+// the last page is shorter than limit.
+func (p *PostgresHandler) LoadVisitedFrontier(afterID int64, limit int) ([]string, int64, error) {
+	if p == nil || p.db == nil {
+		return nil, afterID, fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT id, url FROM crawl_frontier
+		WHERE status IN ($1, $2) AND id > $3
+		ORDER BY id
+		LIMIT $4`, FrontierDone, FrontierFailed, afterID, limit)
+	if err != nil {
+		return nil, afterID, fmt.Errorf("failed to load visited urls: %w", err)
+	}
+	defer rows.Close()
+
+	visited := make([]string, 0, limit)
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&afterID, &url); err != nil {
+			return nil, afterID, fmt.Errorf("failed to scan visited url: %w", err)
+		}
+		visited = append(visited, url)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, afterID, fmt.Errorf("error iterating visited urls: %w", err)
+	}
+
+	return visited, afterID, nil
+}
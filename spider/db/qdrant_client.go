@@ -53,7 +53,7 @@ func CreatePageEmbeddingsCollection() error {
 		return fmt.Errorf("failed to check if page_content_embeddings collection exists: %w", err)
 	}
 	if exists {
-		return nil // Collection already exists
+		return createPayloadIndexes() // Collection already exists
 	}
 
 	err = Client.CreateCollection(context.Background(), &qdrant.CreateCollection{
@@ -67,5 +67,28 @@ func CreatePageEmbeddingsCollection() error {
 		return fmt.Errorf("failed to create dashs_embedding collection: %w", err)
 	}
 
+	return createPayloadIndexes()
+}
+
+// payload fields used in search filters, indexed so filtering does not scan every point
+var payloadIndexes = map[string]qdrant.FieldType{
+	"language":     qdrant.FieldType_FieldTypeKeyword,
+	"entity_type":  qdrant.FieldType_FieldTypeKeyword,
+	"published_at": qdrant.FieldType_FieldTypeInteger,
+}
+
+// createPayloadIndexes is safe to call on every start, existing indexes are left as they are
+func createPayloadIndexes() error {
+	for field, fieldType := range payloadIndexes {
+		_, err := Client.CreateFieldIndex(context.Background(), &qdrant.CreateFieldIndexCollection{
+			CollectionName: "page_content_embeddings",
+			FieldName:      field,
+			FieldType:      fieldType.Enum(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create %s payload index: %w", field, err)
+		}
+	}
+
 	return nil
 }
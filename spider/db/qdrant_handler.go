@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/froxy/models"
@@ -12,14 +13,23 @@ import (
 func UpsertPageToQdrant(client *qdrant.Client, pageData models.PageData) error {
 	ctx := context.Background()
 
-	embedding, err := utils.Embed(pageData.MainContent)
-	if err != nil {
-		fmt.Println(err)
-		return err
+	// a focused crawl already embedded the page to score it
+	embedding := &models.EmbeddingModel{Embedding: pageData.Embedding}
+	var err error
+	if len(embedding.Embedding) == 0 {
+		if embedding, err = utils.Embed(pageData.EmbeddingText()); err != nil {
+			fmt.Println(err)
+			return err
+		}
 	}
 	// Generate deterministic ID from URL
 	pointID := utils.GenerateUUIDFromURL(pageData.URL)
 	fmt.Println(pointID)
+	var authorValues []*qdrant.Value
+	for _, author := range pageData.Authors {
+		authorValues = append(authorValues, qdrant.NewValueString(author))
+	}
+
 	var qdrantValues []*qdrant.Value
 	for _, alt := range pageData.ImageAlt {
 		qdrantValues = append(qdrantValues, &qdrant.Value{
@@ -27,6 +37,22 @@ func UpsertPageToQdrant(client *qdrant.Client, pageData models.PageData) error {
 		})
 	}
 
+	// nested payload, readers get the same shape as the structured_data column
+	var structuredData *qdrant.Value
+	if !pageData.StructuredData.IsEmpty() {
+		encoded, err := json.Marshal(pageData.StructuredData)
+		if err != nil {
+			return fmt.Errorf("failed to encode structured data: %w", err)
+		}
+		var decoded map[string]any
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			return fmt.Errorf("failed to decode structured data: %w", err)
+		}
+		if structuredData, err = qdrant.NewValue(decoded); err != nil {
+			return fmt.Errorf("failed to convert structured data: %w", err)
+		}
+	}
+
 	// Create the point
 	point := &qdrant.PointStruct{
 		Id: &qdrant.PointId{
@@ -57,11 +83,36 @@ func UpsertPageToQdrant(client *qdrant.Client, pageData models.PageData) error {
 					StringValue: pageData.MainContent,
 				},
 			},
+			"markdown": {
+				Kind: &qdrant.Value_StringValue{
+					StringValue: pageData.Markdown,
+				},
+			},
 			"description": {
 				Kind: &qdrant.Value_StringValue{
 					StringValue: pageData.MetaDescription,
 				},
 			},
+			"language": {
+				Kind: &qdrant.Value_StringValue{
+					StringValue: pageData.Language,
+				},
+			},
+			"entity_type": {
+				Kind: &qdrant.Value_StringValue{
+					StringValue: pageData.StructuredData.Type,
+				},
+			},
+			"authors": {
+				Kind: &qdrant.Value_ListValue{
+					ListValue: &qdrant.ListValue{Values: authorValues},
+				},
+			},
+			"page_count": {
+				Kind: &qdrant.Value_IntegerValue{
+					IntegerValue: int64(pageData.PageCount),
+				},
+			},
 			"status": {
 				Kind: &qdrant.Value_IntegerValue{
 					IntegerValue: int64(pageData.StatusCode),
@@ -90,6 +141,17 @@ func UpsertPageToQdrant(client *qdrant.Client, pageData models.PageData) error {
 		},
 	}
 
+	if structuredData != nil {
+		point.Payload["structured_data"] = structuredData
+	}
+	// unix timestamps so apex can filter date ranges, pages without a date have no field
+	if !pageData.PublishedAt.IsZero() {
+		point.Payload["published_at"] = qdrant.NewValueInt(pageData.PublishedAt.Unix())
+	}
+	if !pageData.ModifiedAt.IsZero() {
+		point.Payload["modified_at"] = qdrant.NewValueInt(pageData.ModifiedAt.Unix())
+	}
+
 	// Upsert the point (will insert if new, update if exists)
 	_, err = client.Upsert(ctx, &qdrant.UpsertPoints{
 		CollectionName: "page_content_embeddings",
@@ -98,3 +160,12 @@ func UpsertPageToQdrant(client *qdrant.Client, pageData models.PageData) error {
 
 	return err
 }
+
+// DeletePageFromQdrant removes the point of the url, deleting a missing point is not an error
+func DeletePageFromQdrant(client *qdrant.Client, url string) error {
+	_, err := client.Delete(context.Background(), &qdrant.DeletePoints{
+		CollectionName: "page_content_embeddings",
+		Points:         qdrant.NewPointsSelector(qdrant.NewIDUUID(utils.GenerateUUIDFromURL(url))),
+	})
+	return err
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/froxy/models"
+)
+
+// GetPageFreshness returns the validators and recrawl interval of a stored page, nil if the page is unknown
+func (p *PostgresHandler) GetPageFreshness(url string) (*models.PageFreshness, error) {
+	if p == nil || p.db == nil {
+		return nil, fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT url, COALESCE(etag, ''), last_modified, COALESCE(content_hash, ''), COALESCE(recrawl_interval, 0)
+		FROM pages WHERE url = $1`
+
+	var freshness models.PageFreshness
+	var lastModified sql.NullTime
+	var intervalSeconds int64
+
+	err := p.db.QueryRowContext(ctx, query, url).Scan(
+		&freshness.URL, &freshness.ETag, &lastModified, &freshness.ContentHash, &intervalSeconds,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get page freshness: %w", err)
+	}
+
+	if lastModified.Valid {
+		freshness.LastModified = lastModified.Time
+	}
+	freshness.RecrawlInterval = time.Duration(intervalSeconds) * time.Second
+
+	return &freshness, nil
+}
+
+// MarkPageUnchanged records a recrawl that found the page unchanged (304 or same content hash)
+func (p *PostgresHandler) MarkPageUnchanged(freshness models.PageFreshness) error {
+	if p == nil || p.db == nil {
+		return fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE pages SET
+			etag = COALESCE(NULLIF($2, ''), etag),
+			last_modified = COALESCE($3, last_modified),
+			recrawl_interval = $4,
+			last_checked_at = CURRENT_TIMESTAMP,
+			next_crawl_at = CURRENT_TIMESTAMP + ($4 * INTERVAL '1 second')
+		WHERE url = $1;`
+
+	if _, err := p.db.ExecContext(ctx, query,
+		freshness.URL,
+		freshness.ETag,
+		nullTime(freshness.LastModified),
+		int64(freshness.RecrawlInterval/time.Second),
+	); err != nil {
+		return fmt.Errorf("failed to mark page unchanged: %w", err)
+	}
+	return nil
+}
+
+// MarkPageChecked records a recrawl that did not store the page again (robots, errors, a page now too
+// short...), it is not due again before the interval
+func (p *PostgresHandler) MarkPageChecked(url string, interval time.Duration) error {
+	if p == nil || p.db == nil {
+		return fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE pages SET
+			recrawl_interval = $2,
+			last_checked_at = CURRENT_TIMESTAMP,
+			next_crawl_at = CURRENT_TIMESTAMP + ($2 * INTERVAL '1 second')
+		WHERE url = $1;`
+
+	if _, err := p.db.ExecContext(ctx, query, url, int64(interval/time.Second)); err != nil {
+		return fmt.Errorf("failed to mark page checked: %w", err)
+	}
+	return nil
+}
+
+// DeletePage removes a page that is gone from the site, along with its links and its Qdrant point
+func (p *PostgresHandler) DeletePage(url string) error {
+	if p == nil || p.db == nil {
+		return fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// links of the page go with it (ON DELETE CASCADE)
+	if _, err := p.db.ExecContext(ctx, "DELETE FROM pages WHERE url = $1", url); err != nil {
+		return fmt.Errorf("failed to delete page: %w", err)
+	}
+
+	if err := DeletePageFromQdrant(p.qdrantClient, url); err != nil {
+		return fmt.Errorf("failed to delete page from Qdrant: %w", err)
+	}
+	return nil
+}
+
+// DuePages returns the stored pages whose recrawl time has come, or whose sitemap lastmod is newer than our last check.
+// Pages already waiting in the frontier or being fetched are left out.
+func (p *PostgresHandler) DuePages(limit int) ([]models.Link, error) {
+	if p == nil || p.db == nil {
+		return nil, fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT p.url
+		FROM pages p
+		LEFT JOIN crawl_frontier f ON f.url = p.url
+		WHERE (p.next_crawl_at <= CURRENT_TIMESTAMP
+				OR (f.lastmod IS NOT NULL AND f.lastmod > COALESCE(p.last_checked_at, p.crawl_date)))
+			AND (f.status IS NULL OR f.status NOT IN ($2, $3, $4))
+		ORDER BY p.next_crawl_at
+		LIMIT $1`
+
+	rows, err := p.db.QueryContext(ctx, query, limit, FrontierQueued, FrontierInProgress, FrontierSpilled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get due pages: %w", err)
+	}
+	defer rows.Close()
+
+	links := make([]models.Link, 0)
+	for rows.Next() {
+		var link models.Link
+		if err := rows.Scan(&link.URL); err != nil {
+			return nil, fmt.Errorf("failed to scan due page: %w", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating due pages: %w", err)
+	}
+
+	return links, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t, Valid: true}
+}
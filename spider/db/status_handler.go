@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/froxy/models"
+)
+
+// CrawlStatus counts the stored pages, their aliases and the frontier urls per status
+func (p *PostgresHandler) CrawlStatus() (*models.CrawlStatus, error) {
+	if p == nil || p.db == nil {
+		return nil, fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	status := &models.CrawlStatus{Frontier: make(map[string]int)}
+	var lastCrawl sql.NullTime
+
+	query := `
+		SELECT
+			COUNT(*),
+			COUNT(*) FILTER (WHERE duplicate_of IS NULL),
+			COUNT(*) FILTER (WHERE duplicate_of IS NOT NULL),
+			COUNT(*) FILTER (WHERE next_crawl_at <= CURRENT_TIMESTAMP),
+			MAX(crawl_date)
+		FROM pages`
+	err := p.db.QueryRowContext(ctx, query).Scan(
+		&status.Pages, &status.Indexed, &status.Duplicates, &status.DueForRecrawl, &lastCrawl,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count pages: %w", err)
+	}
+	if lastCrawl.Valid {
+		status.LastCrawl = lastCrawl.Time
+	}
+
+	if err := p.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM url_aliases").Scan(&status.Aliases); err != nil {
+		return nil, fmt.Errorf("failed to count url aliases: %w", err)
+	}
+
+	if err := p.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM crawl_traps").Scan(&status.Traps); err != nil {
+		return nil, fmt.Errorf("failed to count crawl traps: %w", err)
+	}
+
+	rows, err := p.db.QueryContext(ctx, "SELECT status, COUNT(*) FROM crawl_frontier GROUP BY status")
+	if err != nil {
+		return nil, fmt.Errorf("failed to count frontier urls: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var frontierStatus string
+		var count int
+		if err := rows.Scan(&frontierStatus, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan frontier count: %w", err)
+		}
+		status.Frontier[frontierStatus] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating frontier counts: %w", err)
+	}
+
+	return status, nil
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/froxy/models"
+)
+
+// SaveTraps upserts the traps found by the crawler, a pattern once blocked stays blocked
+func (p *PostgresHandler) SaveTraps(traps []models.CrawlTrap) error {
+	if p == nil || p.db == nil {
+		return fmt.Errorf("database handler or connection is nil")
+	}
+	if len(traps) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO crawl_traps (host, pattern, reason, blocked, suppressed, detected_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (host, pattern) DO UPDATE SET
+			reason = CASE WHEN crawl_traps.blocked AND NOT EXCLUDED.blocked THEN crawl_traps.reason ELSE EXCLUDED.reason END,
+			blocked = crawl_traps.blocked OR EXCLUDED.blocked,
+			suppressed = GREATEST(crawl_traps.suppressed, EXCLUDED.suppressed),
+			updated_at = CURRENT_TIMESTAMP;`
+
+	return p.withTransaction(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to prepare trap upsert: %w", err)
+		}
+		defer stmt.Close()
+
+		for _, trap := range traps {
+			_, err := stmt.ExecContext(ctx, trap.Host, trap.Pattern, trap.Reason, trap.Blocked, trap.Suppressed, trap.DetectedAt)
+			if err != nil {
+				return fmt.Errorf("failed to save crawl trap: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// LoadTraps returns the traps found by previous crawls
+func (p *PostgresHandler) LoadTraps() ([]models.CrawlTrap, error) {
+	if p == nil || p.db == nil {
+		return nil, fmt.Errorf("database handler or connection is nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT host, pattern, reason, blocked, suppressed, COALESCE(detected_at, CURRENT_TIMESTAMP)
+		FROM crawl_traps`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load crawl traps: %w", err)
+	}
+	defer rows.Close()
+
+	traps := make([]models.CrawlTrap, 0)
+	for rows.Next() {
+		var trap models.CrawlTrap
+		if err := rows.Scan(&trap.Host, &trap.Pattern, &trap.Reason, &trap.Blocked, &trap.Suppressed, &trap.DetectedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan crawl trap: %w", err)
+		}
+		traps = append(traps, trap)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating crawl traps: %w", err)
+	}
+	return traps, nil
+}
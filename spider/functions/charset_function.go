@@ -0,0 +1,51 @@
+package functions
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// <meta charset> is usually in the first kilobyte, pages with big inline scripts in the head push it further
+const charsetScanLength = 16 * 1024
+
+var metaCharsetPattern = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([\w:.-]+)`)
+
+// decodeBody transcodes the body to UTF-8 and returns the name of its original encoding.
+// A BOM or the Content-Type charset wins, then <meta charset> in the head, then byte sniffing:
+// a body that is valid UTF-8 is kept as is, anything else is read as windows-1252 like browsers do.
+func (c *Crawler) decodeBody(body []byte, contentType string) ([]byte, string) {
+	encoding, name, certain := charset.DetermineEncoding(body, contentType)
+
+	if !certain {
+		head := body
+		if len(head) > charsetScanLength {
+			head = head[:charsetScanLength]
+		}
+
+		declared := ""
+		if match := metaCharsetPattern.FindSubmatch(head); match != nil {
+			if metaEncoding, metaName := charset.Lookup(string(match[1])); metaEncoding != nil {
+				encoding, name = metaEncoding, metaName
+				declared = metaName
+			}
+		}
+
+		if declared == "" && utf8.Valid(body) {
+			return body, "utf-8"
+		}
+	}
+
+	if strings.EqualFold(name, "utf-8") {
+		return body, "utf-8"
+	}
+
+	decoded, err := encoding.NewDecoder().Bytes(body)
+	if err != nil {
+		// keep the raw bytes, cleanContent drops whatever is not valid UTF-8
+		return body, name
+	}
+	return decoded, name
+}
@@ -0,0 +1,36 @@
+package functions
+
+import (
+	"github.com/froxy/config"
+	"github.com/froxy/utils"
+)
+
+// Configure applies the settings to the crawler package, it must run before NewCrawler
+func Configure(cfg config.Config) error {
+	if cfg.URLRulesFile != "" {
+		if err := utils.LoadURLRules(cfg.URLRulesFile); err != nil {
+			return err
+		}
+	}
+
+	timesleep = cfg.Delay
+	requestTimeout = cfg.RequestTimeout
+	userAgent = cfg.UserAgent
+	minContentLength = cfg.MinContentLength
+	maxHTMLBytes = cfg.MaxHTMLBytes
+	maxDocumentBytes = cfg.MaxDocumentBytes
+	frontierWindow = cfg.FrontierWindow
+	fetchConfig = cfg.Fetch
+	resolver = newDNSCache(cfg.Fetch.DNSCacheTTL)
+	maxURLLength = cfg.Traps.MaxURLLength
+	maxURLsPerPattern = cfg.Traps.MaxURLsPerPattern
+	maxParamCombinations = cfg.Traps.MaxParamCombinations
+
+	pool, err := NewProxyPool(cfg.Proxies, cfg.ProxyPool)
+	if err != nil {
+		return err
+	}
+	proxyPool = pool
+
+	return nil
+}
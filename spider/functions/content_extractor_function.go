@@ -0,0 +1,447 @@
+package functions
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/froxy/models"
+	"golang.org/x/net/html"
+)
+
+// Readability-style boilerplate removal.
+// Paragraph-like nodes give points to their parent and grand parent, the best scored
+// container (discounted by its link density) is taken as the article body, and its
+// text is split into blocks (headings, paragraphs, lists, quotes, code, tables).
+
+var (
+	unlikelyCandidates = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|consent|cookie|disqus|extra|footer|gdpr|header|legends|menu|modal|newsletter|outbrain|pager|pagination|popup|promo|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|taboola|tweet|widget`)
+	maybeCandidate     = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+	positiveWeight     = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativeWeight     = regexp.MustCompile(`(?i)-ad-|hidden|banner|combx|comment|com-|contact|cookie|consent|foot|footer|footnote|gdpr|masthead|meta|modal|outbrain|popup|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|social|subscribe|tags|tool|widget`)
+)
+
+const (
+	// text shorter than this inside a paragraph is not worth scoring
+	minParagraphLength = 25
+	// a container made mostly of links (menus, related articles) is dropped from the body
+	maxBlockLinkDensity = 0.5
+)
+
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "details": true,
+	"dialog": true, "dd": true, "div": true, "dl": true, "dt": true, "fieldset": true,
+	"figcaption": true, "figure": true, "footer": true, "form": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true,
+	"main": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true,
+	"table": true, "ul": true,
+}
+
+// findMainContentNodes returns the node holding the article body and its related siblings, nil if none stands out
+func (c *Crawler) findMainContentNodes(doc *html.Node) []*html.Node {
+	scores := make(map[*html.Node]float64)
+	candidates := make([]*html.Node, 0)
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if c.isBoilerplateNode(n) {
+				return
+			}
+
+			switch n.Data {
+			case "p", "pre", "td", "blockquote":
+				candidates = c.scoreParagraph(n, scores, candidates)
+			case "div":
+				if !hasBlockChildren(n) {
+					candidates = c.scoreParagraph(n, scores, candidates)
+				}
+			}
+		}
+
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	var top *html.Node
+	topScore := 0.0
+	for _, candidate := range candidates {
+		score := scores[candidate] * (1 - c.linkDensity(candidate))
+		scores[candidate] = score
+		if score > topScore {
+			top, topScore = candidate, score
+		}
+	}
+
+	if top == nil {
+		return nil
+	}
+
+	// Articles are often split in sibling containers, keep the ones that look like content too
+	nodes := make([]*html.Node, 0)
+	threshold := topScore * 0.2
+	if threshold < 10 {
+		threshold = 10
+	}
+
+	if top.Parent == nil {
+		return []*html.Node{top}
+	}
+
+	for sibling := top.Parent.FirstChild; sibling != nil; sibling = sibling.NextSibling {
+		if sibling.Type != html.ElementNode {
+			continue
+		}
+		if sibling == top {
+			nodes = append(nodes, sibling)
+			continue
+		}
+
+		if score, scored := scores[sibling]; scored && score >= threshold {
+			nodes = append(nodes, sibling)
+			continue
+		}
+
+		if sibling.Data == "p" {
+			text := c.extractTextContent(sibling)
+			if len(text) > 80 && c.linkDensity(sibling) < 0.25 {
+				nodes = append(nodes, sibling)
+			}
+		}
+	}
+
+	return nodes
+}
+
+func (c *Crawler) scoreParagraph(n *html.Node, scores map[*html.Node]float64, candidates []*html.Node) []*html.Node {
+	text := c.extractTextContent(n)
+	if len(text) < minParagraphLength {
+		return candidates
+	}
+
+	score := 1 + float64(strings.Count(text, ",")) + float64(min(len(text)/100, 3))
+
+	divider := 1.0
+	for ancestor, level := n.Parent, 0; ancestor != nil && level < 2; ancestor, level = ancestor.Parent, level+1 {
+		if ancestor.Type != html.ElementNode {
+			break
+		}
+		if _, scored := scores[ancestor]; !scored {
+			scores[ancestor] = c.initialScore(ancestor)
+			candidates = append(candidates, ancestor)
+		}
+		scores[ancestor] += score / divider
+		divider = 2
+	}
+
+	return candidates
+}
+
+func (c *Crawler) initialScore(n *html.Node) float64 {
+	score := c.classWeight(n)
+	switch n.Data {
+	case "article", "main":
+		score += 10
+	case "div":
+		score += 5
+	case "pre", "td", "blockquote":
+		score += 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score -= 3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score -= 5
+	}
+	return score
+}
+
+func (c *Crawler) classWeight(n *html.Node) float64 {
+	weight := 0.0
+	for _, value := range []string{c.getAttributeValue(n, "class"), c.getAttributeValue(n, "id")} {
+		if value == "" {
+			continue
+		}
+		if negativeWeight.MatchString(value) {
+			weight -= 25
+		}
+		if positiveWeight.MatchString(value) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+// linkDensity is the share of the node text that sits inside links
+func (c *Crawler) linkDensity(n *html.Node) float64 {
+	textLength := len(c.extractTextContent(n))
+	if textLength == 0 {
+		return 0
+	}
+
+	linkLength := 0
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "a" {
+			linkLength += len(c.extractTextContent(node))
+			return
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+
+	return float64(linkLength) / float64(textLength)
+}
+
+// isBoilerplateNode reports nodes that never hold the article body
+func (c *Crawler) isBoilerplateNode(n *html.Node) bool {
+	switch n.Data {
+	case "script", "style", "noscript", "nav", "footer", "aside", "header", "form",
+		"iframe", "svg", "button", "select", "template", "dialog":
+		return true
+	}
+
+	if c.hasAttribute(n, "hidden") || c.getAttributeValue(n, "aria-hidden") == "true" {
+		return true
+	}
+	style := strings.ReplaceAll(strings.ToLower(c.getAttributeValue(n, "style")), " ", "")
+	if strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden") {
+		return true
+	}
+
+	if n.Data == "body" || n.Data == "article" || n.Data == "main" {
+		return false
+	}
+	match := c.getAttributeValue(n, "class") + " " + c.getAttributeValue(n, "id")
+	return unlikelyCandidates.MatchString(match) && !maybeCandidate.MatchString(match)
+}
+
+func (c *Crawler) hasAttribute(n *html.Node, attrName string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == attrName {
+			return true
+		}
+	}
+	return false
+}
+
+func hasBlockChildren(n *html.Node) bool {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && blockElements[child.Data] {
+			return true
+		}
+	}
+	return false
+}
+
+// blockCollector turns the selected nodes into content blocks, inline text is buffered until a block boundary
+type blockCollector struct {
+	crawler *Crawler
+	blocks  []models.ContentBlock
+	inline  strings.Builder
+}
+
+// extractContentBlocks splits the main content nodes into blocks that keep paragraph boundaries
+func (c *Crawler) extractContentBlocks(nodes []*html.Node) []models.ContentBlock {
+	collector := &blockCollector{crawler: c, blocks: make([]models.ContentBlock, 0)}
+	for _, n := range nodes {
+		collector.collect(n)
+		collector.flush(minParagraphLength)
+	}
+	return collector.blocks
+}
+
+func (b *blockCollector) add(blockType string, level int, text string) {
+	if text == "" {
+		return
+	}
+	b.blocks = append(b.blocks, models.ContentBlock{Type: blockType, Level: level, Text: text})
+}
+
+// flush emits the buffered inline text as a paragraph if it is long enough
+func (b *blockCollector) flush(minLength int) {
+	text := normalizeSpace(b.inline.String())
+	b.inline.Reset()
+	if len(text) >= minLength {
+		b.add(models.BlockParagraph, 0, text)
+	}
+}
+
+func (b *blockCollector) collect(n *html.Node) {
+	c := b.crawler
+
+	switch n.Type {
+	case html.TextNode:
+		b.inline.WriteString(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			b.collect(child)
+		}
+		return
+	}
+
+	if c.isBoilerplateNode(n) {
+		return
+	}
+
+	if !blockElements[n.Data] {
+		if n.Data == "br" {
+			b.inline.WriteString(" ")
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			b.collect(child)
+		}
+		return
+	}
+
+	b.flush(minParagraphLength)
+
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		b.add(models.BlockHeading, int(n.Data[1]-'0'), normalizeSpace(c.extractTextContent(n)))
+
+	case "p":
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			b.collect(child)
+		}
+		b.flush(1)
+
+	case "ul", "ol":
+		if c.linkDensity(n) > maxBlockLinkDensity {
+			return
+		}
+		items := make([]string, 0)
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode && child.Data == "li" {
+				if item := normalizeSpace(c.extractTextContent(child)); item != "" {
+					items = append(items, item)
+				}
+			}
+		}
+		b.add(models.BlockList, 0, strings.Join(items, "\n"))
+
+	case "pre":
+		b.add(models.BlockCode, 0, strings.Trim(rawText(n), "\n"))
+
+	case "blockquote":
+		b.add(models.BlockQuote, 0, normalizeSpace(c.extractTextContent(n)))
+
+	case "table":
+		if isLayoutTable(n) {
+			b.collectChildren(n)
+			return
+		}
+		rows := make([]string, 0)
+		for _, row := range tableRows(n) {
+			cells := make([]string, 0, len(row))
+			for _, cell := range row {
+				cells = append(cells, normalizeSpace(c.extractTextContent(cell)))
+			}
+			rows = append(rows, strings.Join(cells, " | "))
+		}
+		b.add(models.BlockTable, 0, strings.Join(rows, "\n"))
+
+	case "hr":
+		// nothing to keep, the flush above already closed the paragraph
+
+	default:
+		// generic containers, skip the ones that are mostly links (related articles, tag clouds...)
+		if c.linkDensity(n) > maxBlockLinkDensity && len(c.extractTextContent(n)) < 500 {
+			return
+		}
+		b.collectChildren(n)
+	}
+}
+
+func (b *blockCollector) collectChildren(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.collect(child)
+	}
+	b.flush(minParagraphLength)
+}
+
+// joinBlocks renders the blocks as plain text, one block per paragraph
+func joinBlocks(blocks []models.ContentBlock) string {
+	parts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		parts = append(parts, block.Text)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// isLayoutTable detects tables used to position the page rather than to hold data
+func isLayoutTable(n *html.Node) bool {
+	layout := false
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		for child := node.FirstChild; child != nil && !layout; child = child.NextSibling {
+			if child.Type == html.ElementNode {
+				switch child.Data {
+				case "table", "p", "div", "h1", "h2", "h3", "article", "section":
+					layout = true
+					return
+				}
+			}
+			walk(child)
+		}
+	}
+	walk(n)
+	return layout
+}
+
+// tableRows returns the cells of each row, ignoring nested tables
+func tableRows(table *html.Node) [][]*html.Node {
+	rows := make([][]*html.Node, 0)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.Data {
+			case "table":
+				continue
+			case "tr":
+				cells := make([]*html.Node, 0)
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+						cells = append(cells, cell)
+					}
+				}
+				if len(cells) > 0 {
+					rows = append(rows, cells)
+				}
+			default:
+				walk(child)
+			}
+		}
+	}
+	walk(table)
+	return rows
+}
+
+// rawText returns the text of the node with its whitespace untouched
+func rawText(n *html.Node) string {
+	var builder strings.Builder
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.TextNode {
+			builder.WriteString(node.Data)
+		}
+		if node.Type == html.ElementNode && node.Data == "br" {
+			builder.WriteString("\n")
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return builder.String()
+}
+
+func normalizeSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -2,7 +2,7 @@ package functions
 
 import (
 	"context"
-	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -19,56 +19,38 @@ import (
 	"github.com/froxy/db"
 	"github.com/froxy/models"
 	"github.com/froxy/utils"
-	"github.com/temoto/robotstxt"
 	"golang.org/x/net/html"
 )
 
-// Sitemap structures for XML parsing
-type Sitemap struct {
-	XMLName xml.Name     `xml:"urlset"`
-	URLs    []SitemapURL `xml:"url"`
-}
-
-type SitemapIndex struct {
-	XMLName  xml.Name           `xml:"sitemapindex"`
-	Sitemaps []SitemapReference `xml:"sitemap"`
-}
-
-type SitemapURL struct {
-	Loc        string `xml:"loc"`
-	LastMod    string `xml:"lastmod"`
-	ChangeFreq string `xml:"changefreq"`
-	Priority   string `xml:"priority"`
-}
-
-type SitemapReference struct {
-	Loc     string `xml:"loc"`
-	LastMod string `xml:"lastmod"`
-}
-
 type Crawler struct {
-	BaseDomain   string
-	LinksQueue   *[]models.Link
-	VisitedUrls  map[string]struct{}
-	QueuedUrls   map[string]bool
+	// scope of the seeds given to Start, StartSeeds takes one per seed
+	Scope        models.ScopeConfig
+	VisitedUrls  utils.SeenSet // in memory unless a disk backed set is set before starting
+	frontier     *Frontier
+	refilling    bool
 	Mu           *sync.Mutex
 	Ctx          context.Context
 	cancel       context.CancelFunc
 	shutdownChan chan os.Signal
-	httpClient   *http.Client
+	fetcher      *Fetcher
+	scheduler    *HostScheduler
+	scopes       []*crawlScope
+	attempts     *fetchLog
+	focus        *topicFocus
+	traps        *trapDetector
 }
 
-var robotsCache = make(map[string]*robotstxt.RobotsData)
-var robotsCacheMu sync.RWMutex
-var transport = ProxyTransport()
-
 var (
+	// minimum delay between two requests to the same host (robots.txt Crawl-delay can raise it)
 	timesleep    = 2 * time.Second
 	userAgent    = "FroxyBot/1.0"
 	pagesCrawled = 0
 	// Because we use semantic search now, we need a proper amount of content to embedded for a good results
 	// so for this i added the minimum content length to avoid the pages that are empty or with less content so no meaning with embedding this pages it will just broke the search
 	minContentLength = 500 // Minimum content length requirement
+	// pages whose SimHash differs by at most this many bits are near-duplicates
+	nearDuplicateDistance = 3
+	requestTimeout        = 30 * time.Second
 )
 
 // NewCrawler creates a new crawler instance with proper initialization
@@ -77,60 +59,85 @@ func NewCrawler() *Crawler {
 	shutdownChan := make(chan os.Signal, 1)
 	signal.Notify(shutdownChan, syscall.SIGINT, syscall.SIGTERM)
 
-	linksQueue := make([]models.Link, 0)
-
-	// HTTP client with better settings
-
-	httpClient := &http.Client{
-		Timeout:   30 * time.Second,
-		Transport: transport,
-	}
+	// every request goes through the proxy pool, it sends them directly when there is no proxy
+	go proxyPool.MonitorHealth(ctx)
 
 	crawler := &Crawler{
-		QueuedUrls:   make(map[string]bool),
-		VisitedUrls:  make(map[string]struct{}),
+		frontier:     NewFrontier(),
+		VisitedUrls:  utils.NewMemorySeenSet(),
 		Mu:           &sync.Mutex{},
-		LinksQueue:   &linksQueue,
 		Ctx:          ctx,
 		cancel:       cancel,
 		shutdownChan: shutdownChan,
-		httpClient:   httpClient,
+		fetcher:      NewFetcher(proxyPool),
+		scheduler:    NewHostScheduler(timesleep),
+		attempts:     newFetchLog(),
+		traps:        newTrapDetector(),
 	}
 
 	if crawler.Mu == nil {
 		log.Fatal("Failed to initialize mutex")
 	}
-	if crawler.LinksQueue == nil {
-		log.Fatal("Failed to initialize LinksQueue")
-	}
 
 	return crawler
 }
 
+// Start crawls from the seed urls, all of them within c.Scope
 func (c *Crawler) Start(workerCount int, seedUrls ...string) {
 	if c == nil {
 		log.Fatal("Crawler instance is nil")
 		return
 	}
+
+	seeds := make([]models.Seed, 0, len(seedUrls))
+	for _, seedURL := range seedUrls {
+		seeds = append(seeds, models.Seed{URL: seedURL, Scope: c.Scope})
+	}
+	c.StartSeeds(workerCount, seeds...)
+}
+
+// StartSeeds crawls from the seeds, each one limited to its own scope
+func (c *Crawler) StartSeeds(workerCount int, seeds ...models.Seed) {
+	if c == nil {
+		log.Fatal("Crawler instance is nil")
+		return
+	}
 	if c.Mu == nil {
 		log.Fatal("Crawler mutex is nil")
 		return
 	}
 
-	if len(seedUrls) == 0 {
+	if len(seeds) == 0 {
 		logText := "No seed URLs provided."
 		log.Println(logText)
 		appendLog(logText)
 		return
 	}
 
-	// Set BaseDomain from the first URL for compatibility
-	if parsedURL, err := url.Parse(seedUrls[0]); err == nil {
-		c.BaseDomain = parsedURL.Host
-		log.Printf("Set BaseDomain to: %s", c.BaseDomain)
-		appendLog(fmt.Sprintf("Set BaseDomain to: %s", c.BaseDomain))
+	// Seeds go through the same normalization as every other url
+	seedUrls := make([]string, 0, len(seeds))
+	for _, seed := range seeds {
+		normalized, err := utils.NormalizeURL(seed.URL)
+		if err != nil {
+			log.Printf("Invalid seed URL %s: %v, skipping", seed.URL, err)
+			appendLog(fmt.Sprintf("Invalid seed URL %s: %v, skipping", seed.URL, err))
+			continue
+		}
+		if err := c.addScope(normalized, seed.Scope); err != nil {
+			log.Printf("Invalid scope for seed %s: %v, skipping", seed.URL, err)
+			appendLog(fmt.Sprintf("Invalid scope for seed %s: %v, skipping", seed.URL, err))
+			continue
+		}
+		seedUrls = append(seedUrls, normalized)
+	}
+	if len(seedUrls) == 0 {
+		return
 	}
 
+	// Resume a previous crawl if the persisted frontier still has work
+	c.restoreTraps()
+	resumed := c.restoreFrontier()
+
 	// Process each seed URL individually
 	for i, seedURL := range seedUrls {
 		parsedURL, err := url.Parse(seedURL)
@@ -144,24 +151,36 @@ func (c *Crawler) Start(workerCount int, seedUrls ...string) {
 		log.Printf("Processing seed URL %d/%d: %s", i+1, len(seedUrls), seedURL)
 		appendLog(fmt.Sprintf("Processing seed URL %d/%d: %s", i+1, len(seedUrls), seedURL))
 
-		// Try to crawl from sitemap for this specific domain
-		if err := c.crawlFromSitemap(baseURL); err != nil {
+		// The sitemap was already loaded in the crawl we are resuming
+		if resumed {
+			c.safeEnqueue(models.Link{URL: seedURL})
+			continue
+		}
+
+		// Try to crawl from sitemap for this specific domain. A focused crawl leaves it out,
+		// its urls have no anchor text to score and would bypass the threshold.
+		if c.focus != nil {
+			log.Printf("Focused crawl, not loading the sitemap of %s", baseURL)
+			appendLog(fmt.Sprintf("Focused crawl, not loading the sitemap of %s", baseURL))
+		} else if err := c.crawlFromSitemap(baseURL); err != nil {
 			log.Printf("Failed to crawl from sitemap for %s: %v, using original URL as fallback", baseURL, err)
 			appendLog(fmt.Sprintf("Failed to crawl from sitemap for %s: %v, using original URL as fallback", baseURL, err))
-
-			// Fall back to the original seed URL if sitemap fails
-			c.safeEnqueue(models.Link{URL: seedURL})
-			log.Printf("Added fallback URL to queue: %s", seedURL)
-			appendLog(fmt.Sprintf("Added fallback URL to queue: %s", seedURL))
 		} else {
 			log.Printf("Successfully loaded sitemap for %s", baseURL)
 			appendLog(fmt.Sprintf("Successfully loaded sitemap for %s", baseURL))
 		}
+
+		// A finished crawl left the seed visited, a new one still starts from it
+		if c.readmitSeed(seedURL) {
+			log.Printf("Added seed URL to queue: %s", seedURL)
+			appendLog(fmt.Sprintf("Added seed URL to queue: %s", seedURL))
+		}
 	}
 
 	// Check if we have any URLs in the queue after processing all seeds
+	c.refillFrontier()
 	c.Mu.Lock()
-	queueSize := len(*c.LinksQueue)
+	queueSize := c.frontier.Len()
 	c.Mu.Unlock()
 
 	if queueSize == 0 {
@@ -173,11 +192,21 @@ func (c *Crawler) Start(workerCount int, seedUrls ...string) {
 	log.Printf("Starting crawl with %d URLs in queue", queueSize)
 	appendLog(fmt.Sprintf("Starting crawl with %d URLs in queue", queueSize))
 
+	c.run(workerCount, 10)
+}
+
+// run starts the workers and blocks until they are done.
+// Workers exit after maxEmptyAttempts polls of an empty queue, zero keeps them waiting for new work until shutdown.
+func (c *Crawler) run(workerCount int, maxEmptyAttempts int) {
 	var wg sync.WaitGroup
 
 	// Start shutdown monitor
 	go c.monitorShutdown()
 
+	// Write the fetch attempts as they pile up
+	go c.attempts.run(c.Ctx)
+	go c.traps.run(c.Ctx)
+
 	// Start workers
 	for i := range workerCount {
 		wg.Add(1)
@@ -186,7 +215,6 @@ func (c *Crawler) Start(workerCount int, seedUrls ...string) {
 			defer log.Printf("Worker %d exiting", id)
 
 			consecutiveEmptyAttempts := 0
-			maxEmptyAttempts := 10
 
 			for {
 				select {
@@ -197,9 +225,28 @@ func (c *Crawler) Start(workerCount int, seedUrls ...string) {
 				default:
 				}
 
-				link, ok := c.safeDequeue()
+				c.refillFrontier()
+				link, wait, ok := c.safeDequeue()
+				if !ok && wait > 0 {
+					// there is work, but every queued host is cooling down
+					select {
+					case <-c.Ctx.Done():
+						return
+					case <-time.After(wait):
+						continue
+					}
+				}
+
 				if !ok {
 					consecutiveEmptyAttempts++
+					if maxEmptyAttempts == 0 {
+						select {
+						case <-c.Ctx.Done():
+							return
+						case <-time.After(recrawlIdleWait):
+							continue
+						}
+					}
 					if consecutiveEmptyAttempts >= maxEmptyAttempts {
 						log.Printf("Worker %d: No work for %d attempts, exiting", id, maxEmptyAttempts)
 						return
@@ -218,84 +265,20 @@ func (c *Crawler) Start(workerCount int, seedUrls ...string) {
 				consecutiveEmptyAttempts = 0
 
 				log.Printf("Worker %d: Processing %s", id, link.URL)
-				if err := c.CrawlPage(link.URL); err != nil {
+				if err := c.CrawlPage(link); err != nil {
 					log.Printf("Worker %d: Error crawling %s: %v", id, link.URL, err)
 				}
-
-				select {
-				case <-c.Ctx.Done():
-					return
-				case <-time.After(timesleep):
-					// Continue to next iteration
-				}
+				c.releaseHost(hostOf(link.URL))
 			}
 		}(i)
 	}
 
 	wg.Wait()
+	c.attempts.flush()
+	c.traps.save()
 	log.Printf("All workers finished. Total pages crawled: %d", pagesCrawled)
 }
 
-// crawlFromSitemap attempts to populate the queue from sitemap.xml
-func (c *Crawler) crawlFromSitemap(baseURL string) error {
-	sitemapURLs := []string{
-		baseURL + "/sitemap.xml",
-		baseURL + "/sitemap_index.xml",
-		baseURL + "/sitemaps.xml",
-	}
-
-	for _, sitemapURL := range sitemapURLs {
-		log.Printf("Trying to fetch sitemap from: %s", sitemapURL)
-
-		resp, err := c.httpClient.Get(sitemapURL)
-		if err != nil {
-			log.Printf("Failed to fetch sitemap from %s: %v", sitemapURL, err)
-			continue
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Sitemap not found at %s, status: %d", sitemapURL, resp.StatusCode)
-			continue
-		}
-
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("Failed to read sitemap body from %s: %v", sitemapURL, err)
-			continue
-		}
-
-		// Try to parse as regular sitemap first
-		var sitemap Sitemap
-		if err := xml.Unmarshal(body, &sitemap); err == nil && len(sitemap.URLs) > 0 {
-			log.Printf("Found sitemap with %d URLs", len(sitemap.URLs))
-			for _, url := range sitemap.URLs {
-				c.safeEnqueue(models.Link{URL: url.Loc})
-			}
-			return nil
-		}
-
-		// Try to parse as sitemap index
-		var sitemapIndex SitemapIndex
-		if err := xml.Unmarshal(body, &sitemapIndex); err == nil && len(sitemapIndex.Sitemaps) > 0 {
-			log.Printf("Found sitemap index with %d sitemaps", len(sitemapIndex.Sitemaps))
-			for _, sitemapRef := range sitemapIndex.Sitemaps {
-				if err := c.crawlFromSitemap(sitemapRef.Loc); err != nil {
-					log.Printf("Failed to crawl nested sitemap %s: %v", sitemapRef.Loc, err)
-				}
-			}
-			return nil
-		}
-
-		log.Printf("Failed to parse sitemap from %s", sitemapURL)
-	}
-
-	// No sitemap found, add the base URL directly to queue
-	log.Printf("No valid sitemap found for %s, adding base URL to queue", baseURL)
-	c.safeEnqueue(models.Link{URL: baseURL})
-	return nil
-}
-
 func (c *Crawler) monitorShutdown() {
 	<-c.shutdownChan
 	log.Println("Shutdown signal received. Initiating graceful shutdown...")
@@ -309,68 +292,315 @@ func (c *Crawler) monitorShutdown() {
 	}()
 }
 
-func (c *Crawler) safeDequeue() (models.Link, bool) {
-	if c == nil || c.Mu == nil || c.LinksQueue == nil || c.QueuedUrls == nil {
+// safeDequeue returns the best queued link whose host is allowed to be fetched now.
+// When every queued host is cooling down it returns false with the time to wait.
+// The host stays reserved until the caller releases it with releaseHost.
+func (c *Crawler) safeDequeue() (models.Link, time.Duration, bool) {
+	if c == nil || c.Mu == nil || c.frontier == nil {
 		log.Printf("ERROR: Crawler or its components are nil in safeDequeue")
-		return models.Link{}, false
+		return models.Link{}, 0, false
 	}
 
 	c.Mu.Lock()
-	defer c.Mu.Unlock()
+	link, wait, ok := c.frontier.Pop(c.scheduler, time.Now())
+	queueSize := c.frontier.Len()
+	c.Mu.Unlock()
 
-	if len(*c.LinksQueue) == 0 {
-		return models.Link{}, false
+	if !ok {
+		return models.Link{}, wait, false
 	}
 
-	link, newQueue, err := utils.Dequeue(*c.LinksQueue)
-	if err != nil {
-		log.Printf("ERROR: Failed to dequeue: %v", err)
-		return models.Link{}, false
+	log.Printf("Dequeued: %s, Queue size: %d", link.URL, queueSize)
+
+	if err := db.GetPostgresHandler().UpdateFrontierStatus(link.URL, db.FrontierInProgress); err != nil {
+		log.Printf("Failed to mark %s as in progress: %v", link.URL, err)
 	}
+	return link, 0, true
+}
 
-	*c.LinksQueue = newQueue
-	delete(c.QueuedUrls, link.URL)
+// releaseHost ends the fetch of a host, its next link waits for the scheduler delay
+func (c *Crawler) releaseHost(host string) {
+	c.scheduler.Release(host)
 
-	log.Printf("Dequeued: %s, Queue size: %d", link.URL, len(*c.LinksQueue))
-	return link, true
+	c.Mu.Lock()
+	c.frontier.Release(host, c.scheduler.ReadyIn(host, time.Now()))
+	c.Mu.Unlock()
 }
 
-func (c *Crawler) safeEnqueue(link models.Link) {
-	if c == nil || c.Mu == nil || c.LinksQueue == nil || c.QueuedUrls == nil || c.VisitedUrls == nil {
+// safeEnqueue queues a new url within the page limits of its scope, it reports whether the url was queued.
+// Past the in-memory window of the frontier the url is only written to crawl_frontier.
+// Finding an url that is already queued counts as one more inlink.
+func (c *Crawler) safeEnqueue(link models.Link) bool {
+	if c == nil || c.Mu == nil || c.frontier == nil || c.VisitedUrls == nil {
 		log.Printf("ERROR: Crawler components are nil")
+		return false
+	}
+
+	normalized, err := utils.NormalizeURL(link.URL)
+	if err != nil {
+		log.Printf("Failed to normalize URL %s: %v", link.URL, err)
+		return false
+	}
+	link.URL = normalized
+
+	c.Mu.Lock()
+	if c.knownLocked(link.URL) {
+		c.frontier.AddInlink(link.URL)
+		c.Mu.Unlock()
+		return false
+	}
+	admitted, spill := c.queueLocked(link, false)
+	c.Mu.Unlock()
+	if !admitted {
+		return false
+	}
+
+	inserted, err := db.GetPostgresHandler().EnqueueFrontier(link, linkScore(link), spill)
+	if err != nil {
+		log.Printf("Failed to persist %s to the frontier: %v", link.URL, err)
+		return !spill
+	}
+	if !inserted {
+		// already spilled, it was counted then
+		c.Mu.Lock()
+		c.unadmitLocked(link.URL)
+		c.traps.release(link.URL)
+		c.Mu.Unlock()
+		return !spill
+	}
+	return true
+}
+
+// readmitSeed queues a seed even if an earlier crawl visited it. Only the seed is forgotten,
+// the pages it leads to that were already crawled are left to the recrawl.
+func (c *Crawler) readmitSeed(seedURL string) bool {
+	c.Mu.Lock()
+	queued := c.frontier.Contains(seedURL)
+	visited := c.VisitedUrls.Contains(seedURL)
+	c.Mu.Unlock()
+	if queued {
+		return false
+	}
+
+	if visited {
+		if err := c.VisitedUrls.Remove(seedURL); err != nil {
+			log.Printf("Failed to forget seed URL %s: %v", seedURL, err)
+			return false
+		}
+		// the row is done or failed, EnqueueFrontier would leave it so
+		if err := db.GetPostgresHandler().UpdateFrontierStatus(seedURL, db.FrontierQueued); err != nil {
+			log.Printf("Failed to requeue seed URL %s: %v", seedURL, err)
+		}
+	}
+	return c.safeEnqueue(models.Link{URL: seedURL})
+}
+
+// queueLocked runs a link that is not queued through the trap and scope checks, then puts it in the
+// in-memory frontier, or leaves it to crawl_frontier when the window is full. An url the scope already
+// counted (a page being revisited) is not counted again. It reports whether the link was admitted and
+// whether it spilled. The caller must hold c.Mu
+func (c *Crawler) queueLocked(link models.Link, counted bool) (bool, bool) {
+	if reason := c.traps.admit(link); reason != "" {
+		return false, false
+	}
+	if !counted && !c.admitLocked(link.URL) {
+		c.traps.release(link.URL)
+		return false, false
+	}
+
+	spill := c.frontier.Full()
+	if spill {
+		c.frontier.spilled = true
+	} else {
+		c.enqueueLocked(link)
+	}
+	return true, spill
+}
+
+// knownLocked reports whether the url is already queued or visited, the caller must hold c.Mu
+func (c *Crawler) knownLocked(url string) bool {
+	return c.frontier.Contains(url) || c.VisitedUrls.Contains(url)
+}
+
+// enqueueLocked adds the link to the in-memory frontier, the caller must hold c.Mu
+func (c *Crawler) enqueueLocked(link models.Link) bool {
+	if c.knownLocked(link.URL) {
+		return false
+	}
+
+	c.frontier.Push(link)
+
+	log.Printf("Enqueued: %s, Queue size: %d", link.URL, c.frontier.Len())
+	appendLog(fmt.Sprintf("Enqueued: %s, Queue size: %d", link.URL, c.frontier.Len()))
+	return true
+}
+
+// refillFrontier loads spilled links back once the in-memory frontier has room for them
+func (c *Crawler) refillFrontier() {
+	c.Mu.Lock()
+	room := frontierWindow - c.frontier.Len()
+	if !c.frontier.spilled || c.refilling || room < frontierWindow/2 {
+		c.Mu.Unlock()
 		return
 	}
+	c.refilling = true
+	c.Mu.Unlock()
+
+	links, err := db.GetPostgresHandler().LoadSpilledFrontier(room)
 
 	c.Mu.Lock()
 	defer c.Mu.Unlock()
+	c.refilling = false
 
-	if _, exists := c.QueuedUrls[link.URL]; exists {
+	if err != nil {
+		log.Printf("Failed to load spilled urls: %v", err)
 		return
 	}
+	for _, link := range links {
+		c.enqueueLocked(link)
+	}
+	if len(links) < room {
+		c.frontier.spilled = false
+	}
 
-	if _, visited := c.VisitedUrls[link.URL]; visited {
-		return
+	if len(links) > 0 {
+		log.Printf("Loaded %d spilled urls back into the frontier", len(links))
+		appendLog(fmt.Sprintf("Loaded %d spilled urls back into the frontier", len(links)))
+	}
+}
+
+// restoreFrontier loads the persisted frontier of a previous crawl into memory.
+// It reports whether there was pending work to resume.
+func (c *Crawler) restoreFrontier() bool {
+	links, err := db.GetPostgresHandler().LoadFrontier(frontierWindow)
+	if err != nil {
+		log.Printf("Failed to load the persisted frontier: %v", err)
+		appendLog(fmt.Sprintf("Failed to load the persisted frontier: %v", err))
+		return false
+	}
+
+	// a seen set on disk still has the urls of the previous crawl
+	if _, persistent := c.VisitedUrls.(*utils.DiskSeenSet); !persistent {
+		c.restoreVisited()
 	}
 
-	*c.LinksQueue = utils.Enqueue(*c.LinksQueue, link)
-	c.QueuedUrls[link.URL] = true
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	// links the previous crawl had no room for are loaded as the frontier empties
+	c.frontier.spilled = true
+
+	for _, link := range links {
+		if !c.knownLocked(link.URL) {
+			c.admitLocked(link.URL)
+		}
+		c.enqueueLocked(link)
+	}
+
+	if len(links) == 0 {
+		return false
+	}
 
-	log.Printf("Enqueued: %s, Queue size: %d", link.URL, len(*c.LinksQueue))
-	appendLog(fmt.Sprintf("Enqueued: %s, Queue size: %d", link.URL, len(*c.LinksQueue)))
+	log.Printf("Resuming crawl: %d queued urls, %d already visited", c.frontier.Len(), c.VisitedUrls.Len())
+	appendLog(fmt.Sprintf("Resuming crawl: %d queued urls, %d already visited", c.frontier.Len(), c.VisitedUrls.Len()))
+	return true
 }
 
-func (c *Crawler) CrawlPage(websiteUrl string) error {
+// visited urls read at once when a crawl is resumed
+const visitedPageSize = 10000
+
+// restoreVisited adds the urls the previous crawl was done with to the seen set, a page of them at a time
+func (c *Crawler) restoreVisited() {
+	var afterID int64
+	for {
+		visited, lastID, err := db.GetPostgresHandler().LoadVisitedFrontier(afterID, visitedPageSize)
+		if err != nil {
+			log.Printf("Failed to load the visited urls: %v", err)
+			appendLog(fmt.Sprintf("Failed to load the visited urls: %v", err))
+			return
+		}
+		afterID = lastID
+
+		c.Mu.Lock()
+		for i, url := range visited {
+			if normalized, err := utils.NormalizeURL(url); err == nil {
+				url = normalized
+				visited[i] = url
+			}
+			if !c.knownLocked(url) {
+				c.admitLocked(url)
+			}
+		}
+		if err := c.VisitedUrls.Add(visited...); err != nil {
+			log.Printf("Failed to restore the visited urls: %v", err)
+		}
+		c.Mu.Unlock()
+
+		if len(visited) < visitedPageSize {
+			return
+		}
+	}
+}
+
+func (c *Crawler) CrawlPage(link models.Link) error {
+	// links are normalized when queued, except the ones persisted by an older frontier
+	websiteUrl, err := utils.NormalizeURL(link.URL)
+	if err != nil {
+		log.Printf("Failed to normalize URL %s: %v", link.URL, err)
+		c.addToSeen(link.URL)
+		return fmt.Errorf("failed to normalize URL: %w", err)
+	}
 	log.Printf("Crawling: %s", websiteUrl)
 	pagesCrawled++
-	c.httpClient.Transport = ProxyTransport()
-	defer c.addToSeen(websiteUrl)
+	// urls that turned out to serve this page (redirects), they are done with it
+	seenAliases := []string{}
+	// set when the fetch failed for a reason that may go away, the url gets another pass later
+	retryLater := false
+	// the stored page being recrawled, nil on a first visit
+	var recrawled *models.PageFreshness
+	// set once the stored page was updated, replaced or deleted
+	settled := false
+	defer func() {
+		// A page interrupted by shutdown stays in progress so the next run picks it up again
+		if c.Ctx.Err() == nil {
+			// a recrawl that ends early must still push the page back, or it is due on every check
+			if recrawled != nil && !settled {
+				c.postponeRecrawl(*recrawled)
+			}
+			if retryLater {
+				c.markFailed(websiteUrl)
+			} else {
+				c.addToSeen(websiteUrl)
+			}
+			if link.URL != websiteUrl {
+				c.addToSeen(link.URL)
+			}
+			for _, alias := range seenAliases {
+				if alias != websiteUrl {
+					c.addToSeen(alias)
+				}
+			}
+		}
+	}()
 
-	if _, visited := c.VisitedUrls[websiteUrl]; visited {
+	if c.VisitedUrls.Contains(websiteUrl) {
 		log.Printf("%s already visited, skipping", websiteUrl)
 		appendLog(fmt.Sprintf("%s already visited, skipping", websiteUrl))
 		return nil
 	}
 
+	// What we know from the previous crawl of this page, if any
+	freshness, err := db.GetPostgresHandler().GetPageFreshness(websiteUrl)
+	if err != nil {
+		log.Printf("Failed to get freshness of %s, doing a full fetch: %v", websiteUrl, err)
+	}
+	recrawled = freshness
+
+	// queued before its pattern turned out to be a trap
+	if c.traps.blocked(websiteUrl) {
+		return nil
+	}
+
 	// Skip non-HTML content based on URL patterns
 	if c.shouldSkipURL(websiteUrl) {
 		log.Printf("Skipping non-HTML content: %s", websiteUrl)
@@ -385,7 +615,7 @@ func (c *Crawler) CrawlPage(websiteUrl string) error {
 
 	protocol := parsedURL.Scheme + "://"
 	domain := parsedURL.Host
-	targetPath := parsedURL.Path
+	targetPath := parsedURL.RequestURI()
 
 	if err := c.CheckingRobotsRules((protocol + domain), targetPath); err != nil {
 		log.Printf("Robots.txt blocked %s: %v", websiteUrl, err)
@@ -393,56 +623,128 @@ func (c *Crawler) CrawlPage(websiteUrl string) error {
 		return fmt.Errorf("robots.txt blocked: %w", err)
 	}
 
-	// Create context with timeout for this request
-	ctx, cancel := context.WithTimeout(c.Ctx, 30*time.Second)
-	defer cancel()
-
-	startTime := time.Now()
-
-	request, err := http.NewRequestWithContext(ctx, "GET", websiteUrl, nil)
+	resp, timing, err := c.fetchPage(websiteUrl, freshness)
 	if err != nil {
-		log.Printf("Failed to create request for %s: %v", websiteUrl, err)
-		return fmt.Errorf("failed to create request: %w", err)
+		failedLink := link
+		failedLink.URL = websiteUrl
+		retryLater = c.recordFetchFailure(failedLink, err)
+
+		var fetchErr *FetchError
+		if recrawled != nil && errors.As(err, &fetchErr) &&
+			(fetchErr.StatusCode == http.StatusNotFound || fetchErr.StatusCode == http.StatusGone) {
+			c.dropGonePage(websiteUrl, fetchErr.StatusCode)
+			settled = true
+		}
+		return fmt.Errorf("failed to fetch page: %w", err)
 	}
+	defer resp.Body.Close()
 
-	request.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-	request.Header.Set("User-Agent", userAgent)
-	// request.Header.Set("Accept-Language", "en-US,en;q=0.5")
-
-	resp, err := c.httpClient.Do(request)
-	responseTime := time.Since(startTime)
+	if err := db.GetPostgresHandler().ClearFailedURL(websiteUrl); err != nil {
+		log.Printf("Failed to clear the failures of %s: %v", websiteUrl, err)
+	}
 
-	if err != nil {
-		log.Printf("Failed to fetch %s: %v", websiteUrl, err)
-		return fmt.Errorf("failed to fetch page: %w", err)
+	if resp.StatusCode == http.StatusNotModified {
+		settled = true
+		return c.markUnchanged(*freshness, resp)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Skipped %s, status: %d", websiteUrl, resp.StatusCode)
-		return fmt.Errorf("non-200 status code: %d", resp.StatusCode)
+	// The client follows redirects, the page belongs to the url it ended on
+	finalURL := websiteUrl
+	chain := redirectChain(resp)
+	if len(chain) > 0 {
+		if normalized, err := utils.NormalizeURL(resp.Request.URL.String()); err == nil {
+			finalURL = normalized
+		}
+		log.Printf("%s redirected to %s (%d hops)", websiteUrl, finalURL, len(chain))
+		appendLog(fmt.Sprintf("%s redirected to %s (%d hops)", websiteUrl, finalURL, len(chain)))
+
+		if finalURL != websiteUrl {
+			if c.VisitedUrls.Contains(finalURL) {
+				log.Printf("%s already visited, recording %s as its alias", finalURL, websiteUrl)
+				settled = true
+				return db.GetPostgresHandler().RecordAliases(finalURL, pageAliases(finalURL, finalURL, chain))
+			}
+			seenAliases = append(chain, finalURL)
+
+			domain = resp.Request.URL.Host
+			protocol = resp.Request.URL.Scheme + "://"
+		}
 	}
 
-	// Validate content type
+	// Validate content type, documents get their own extractor
 	contentType := resp.Header.Get("Content-Type")
-	if !c.isHTMLContent(contentType) {
-		log.Printf("Skipping non-HTML content: %s (Content-Type: %s)", websiteUrl, contentType)
+	kind := c.documentKind(contentType, websiteUrl)
+	if kind == "" {
+		log.Printf("Skipping unsupported content: %s (Content-Type: %s)", websiteUrl, contentType)
 		return nil
 	}
 
 	// Limit body size to prevent memory issues
-	limitedReader := io.LimitReader(resp.Body, 10*1024*1024) // 10MB limit
-	bodyData, err := io.ReadAll(limitedReader)
+	maxBytes := maxHTMLBytes
+	if kind != documentHTML {
+		maxBytes = maxDocumentBytes
+	}
+	bodyData, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes))
 	if err != nil {
 		log.Printf("Failed to read body for %s: %v", websiteUrl, err)
 		return fmt.Errorf("failed to read body: %w", err)
 	}
 
-	pageData, err := c.extractPageData(string(bodyData), websiteUrl, domain, protocol, resp, responseTime)
+	var pageData *models.PageData
+	if kind == documentHTML {
+		// html.Parse expects UTF-8, pages in other charsets are transcoded first
+		var encoding string
+		bodyData, encoding = c.decodeBody(bodyData, contentType)
+
+		pageData, err = c.extractPageData(string(bodyData), finalURL, domain, protocol, resp, timing.ResponseTime)
+		if err == nil {
+			pageData.Encoding = encoding
+		}
+	} else {
+		pageData, err = c.extractDocumentData(kind, bodyData, finalURL, resp, timing.ResponseTime)
+	}
 	if err != nil {
 		log.Printf("Failed to extract page data for %s: %v", websiteUrl, err)
 		return fmt.Errorf("failed to extract page data: %w", err)
 	}
+	pageData.TimeToFirstByte = timing.TimeToFirstByte
+
+	// cleaned before the links are queued, a focused crawl embeds it to score them
+	pageData.MainContent = c.cleanContent(pageData.MainContent)
+	// pages of a pattern that all look the same get the pattern blocked before its links are queued
+	pageData.SimHash = utils.SimHash(pageData.MainContent)
+	c.traps.observe(websiteUrl, pageData.SimHash)
+	c.enqueueOutboundLinks(link, pageData)
+
+	// An immediate meta refresh is a redirect the client could not follow, the target is crawled instead
+	if target := refreshTarget(pageData.MetaRefresh, finalURL); target != "" && target != finalURL {
+		aliases := append(pageAliases(finalURL, finalURL, chain), models.URLAlias{URL: finalURL, Reason: models.AliasRefresh})
+		log.Printf("%s refreshes to %s, queueing the target", finalURL, target)
+		appendLog(fmt.Sprintf("%s refreshes to %s, queueing the target", finalURL, target))
+
+		targetLink := models.Link{Text: link.Text, URL: target, Priority: link.Priority, Depth: link.Depth}
+		if c.inScope(targetLink, finalURL) {
+			c.safeEnqueue(targetLink)
+		}
+		// the page stored under the url, if any, is dropped with the alias
+		settled = true
+		return db.GetPostgresHandler().RecordAliases(target, aliases)
+	}
+
+	// Stored under its canonical url, every other url that led here is an alias
+	pageData.URL = finalURL
+	if canonical := canonicalURL(pageData, resp, finalURL); canonical != "" {
+		pageData.URL = canonical
+	}
+	pageData.RedirectChain = chain
+	pageData.Aliases = pageAliases(pageData.URL, finalURL, chain)
+	if pageData.URL != websiteUrl {
+		log.Printf("Storing %s under %s", websiteUrl, pageData.URL)
+		freshness, err = db.GetPostgresHandler().GetPageFreshness(pageData.URL)
+		if err != nil {
+			log.Printf("Failed to get freshness of %s: %v", pageData.URL, err)
+		}
+	}
 
 	// Check content length requirement
 	if len(pageData.MainContent) < minContentLength {
@@ -450,11 +752,33 @@ func (c *Crawler) CrawlPage(websiteUrl string) error {
 		return nil
 	}
 
+	// Same content as last time, no need to embed it again
+	pageData.ContentHash = contentHash(pageData)
+	if freshness != nil && freshness.ContentHash == pageData.ContentHash {
+		settled = true
+		return c.markUnchanged(*freshness, resp)
+	}
+	pageData.RecrawlInterval = initialRecrawlInterval(link)
+	if freshness != nil {
+		pageData.RecrawlInterval = nextRecrawlInterval(freshness.RecrawlInterval, true)
+	}
+
+	// Mirrors, print views and templated copies are stored but not indexed again
+	canonical, err := db.GetPostgresHandler().FindNearDuplicate(pageData.URL, pageData.SimHash, nearDuplicateDistance)
+	if err != nil {
+		log.Printf("Failed to look for near-duplicates of %s: %v", pageData.URL, err)
+	} else if canonical != "" {
+		pageData.DuplicateOf = canonical
+		log.Printf("%s is a near-duplicate of %s", pageData.URL, canonical)
+		appendLog(fmt.Sprintf("%s is a near-duplicate of %s", pageData.URL, canonical))
+	}
+
 	// Store data with retry logic and exponential backoff
 	if err := c.storePageDataWithRetry(pageData, 3); err != nil {
 		log.Printf("Failed to store page data after retries for %s: %v", websiteUrl, err)
 		return fmt.Errorf("failed to store page data: %w", err)
 	}
+	settled = true
 
 	log.Printf("Successfully processed %s, found %d outbound links", websiteUrl, len(pageData.OutboundLinks))
 	return nil
@@ -492,21 +816,6 @@ func (c *Crawler) storePageDataWithRetry(pageData *models.PageData, maxRetries i
 			continue
 		}
 
-		// "remove all leading / from the end of the url"
-		// why ?
-		// we use the GenerateUUIDFromURL in the utils file , this made a hash from the url then convert it to uuid v4
-		// this proccess to make each url have unique id from the other to prevent the duplicate
-		// now there is one issue with this way
-		// the url https://google.com
-		// have different hash than: https://google.com/
-		// and this will make duplicates on our database
-		// now why we do not store the url directly to be the primary key ?
-		// qdrant dose not support this it support only integers or uuid`s
-
-		for pageData.URL[len(pageData.URL)-1] == '/' {
-			pageData.URL = pageData.URL[0:(len(pageData.URL) - 1)]
-		}
-
 		err := db.GetPostgresHandler().UpsertPageData(*pageData)
 		if err == nil {
 			log.Printf("Successfully stored page data for: %s", pageData.URL)
@@ -550,14 +859,29 @@ func (c *Crawler) extractPageData(htmlContent, url, domain, protocol string, res
 	}
 
 	if lastMod := resp.Header.Get("Last-Modified"); lastMod != "" {
-		if parsed, err := time.Parse(time.RFC1123, lastMod); err == nil {
+		if parsed, err := http.ParseTime(lastMod); err == nil {
 			pageData.LastModified = parsed
 		}
 	}
+	pageData.ETag = resp.Header.Get("ETag")
 
 	c.extractHTMLData(doc, pageData, domain, protocol)
+	pageData.StructuredData = c.extractStructuredData(doc)
+	c.extractDates(doc, pageData)
+
+	// Keep only the article body when we can isolate it, the whole page text stays as a fallback
+	if nodes := c.findMainContentNodes(doc); len(nodes) > 0 {
+		blocks := c.extractContentBlocks(nodes)
+		mainContent := joinBlocks(blocks)
+		if len(mainContent) >= minContentLength || len(mainContent) >= len(pageData.MainContent)/2 {
+			pageData.Blocks = blocks
+			pageData.MainContent = mainContent
+			pageData.Markdown = c.renderMarkdown(nodes)
+		}
+	}
 
 	pageData.WordCount = len(strings.Fields(pageData.MainContent))
+	pageData.Language = detectPageLanguage(pageData)
 
 	return pageData, nil
 }
@@ -565,6 +889,11 @@ func (c *Crawler) extractPageData(htmlContent, url, domain, protocol string, res
 func (c *Crawler) extractHTMLData(n *html.Node, pageData *models.PageData, domain, protocol string) {
 	if n.Type == html.ElementNode {
 		switch n.Data {
+		case "html":
+			if lang := c.getAttributeValue(n, "lang"); lang != "" {
+				pageData.Language = lang
+			}
+
 		case "title":
 			// Extract the actual page title from the <title> tag
 			titleText := c.extractTextContent(n)
@@ -607,7 +936,7 @@ func (c *Crawler) extractHTMLData(n *html.Node, pageData *models.PageData, domai
 	}
 
 	if n.Type == html.TextNode && !c.isInIgnoredElement(n) {
-		text := strings.TrimSpace(n.Data)
+		text := normalizeSpace(n.Data)
 		if text != "" && len(text) > 3 {
 			pageData.MainContent += " " + text
 		}
@@ -631,12 +960,17 @@ func (c *Crawler) extractMetaData(n *html.Node, pageData *models.PageData) {
 	case name == "keywords":
 		pageData.MetaKeywords = content
 	case name == "language" || property == "og:locale":
-		pageData.Language = content
+		// <html lang> is more reliable, it is seen first
+		if pageData.Language == "" {
+			pageData.Language = content
+		}
 	case property == "og:title":
 		// Use og:title as fallback if no title tag found
 		if pageData.Title == "" {
 			pageData.Title = content
 		}
+	case strings.EqualFold(c.getAttributeValue(n, "http-equiv"), "refresh"):
+		pageData.MetaRefresh = content
 	}
 }
 
@@ -674,25 +1008,17 @@ func (c *Crawler) extractLinkData(linkNode *html.Node, pageData *models.PageData
 		return
 	}
 
-	cleanURL, err := utils.CanonicalizeURL(fullURL)
+	cleanURL, err := utils.NormalizeURL(fullURL)
 	if err != nil {
-		log.Printf("Failed to canonicalize URL %s: %v", fullURL, err)
+		log.Printf("Failed to normalize URL %s: %v", fullURL, err)
 		return
 	}
 
-	// Store the link with its text (NOT as the page title)
+	// Store the link with its text (NOT as the page title), the crawler queues the ones in scope
 	pageData.OutboundLinks = append(pageData.OutboundLinks, models.Link{
 		Text: linkText,
 		URL:  cleanURL,
 	})
-
-	// Only enqueue links from the same domain
-	if parsedURL, err := url.Parse(cleanURL); err == nil {
-		if parsedURL.Host == domain || parsedURL.Host == c.BaseDomain {
-			link := models.Link{Text: linkText, URL: cleanURL}
-			c.safeEnqueue(link)
-		}
-	}
 }
 
 func (c *Crawler) getAttributeValue(n *html.Node, attrName string) string {
@@ -742,9 +1068,10 @@ func (c *Crawler) cleanContent(content string) string {
 	// Remove invalid UTF-8 sequences
 	content = strings.ToValidUTF8(content, "")
 
-	// Replace multiple whitespace with single space
-	re := regexp.MustCompile(`\s+`)
-	content = re.ReplaceAllString(content, " ")
+	// Replace multiple whitespace with single space, but keep the line and paragraph breaks of the blocks
+	content = regexp.MustCompile(`[^\S\n]+`).ReplaceAllString(content, " ")
+	content = regexp.MustCompile(` ?\n ?`).ReplaceAllString(content, "\n")
+	content = regexp.MustCompile(`\n{3,}`).ReplaceAllString(content, "\n\n")
 
 	// Remove problematic content
 	content = strings.ReplaceAll(content, "JavaScript", "")
@@ -757,53 +1084,11 @@ func (c *Crawler) cleanContent(content string) string {
 	return strings.TrimSpace(content)
 }
 
-func (c *Crawler) CheckingRobotsRules(domain string, targetPath string) error {
-	robotsCacheMu.RLock()
-	robotsData, exists := robotsCache[domain]
-	robotsCacheMu.RUnlock()
-
-	if exists {
-		group := robotsData.FindGroup("*")
-		if !group.Test(targetPath) {
-			return fmt.Errorf("blocked by robots.txt: %s", targetPath)
-		}
-		return nil
-	}
-
-	resp, err := http.Get(domain + "/robots.txt")
-	if err != nil {
-		return fmt.Errorf("failed to fetch robots.txt: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusNotFound {
-		return nil
-	}
-
-	robotsData, err = robotstxt.FromResponse(resp)
-	if err != nil {
-		return fmt.Errorf("failed to parse robots.txt: %v", err)
-	}
-
-	group := robotsData.FindGroup("*")
-	canFetch := group.Test(targetPath)
-
-	if !canFetch {
-		return fmt.Errorf("not allowed to fetch %s (blocked by robots.txt)", targetPath)
-	}
-
-	robotsCacheMu.Lock()
-	robotsCache[domain] = robotsData
-	robotsCacheMu.Unlock()
-
-	return nil
-}
-
 func (c *Crawler) shouldSkipURL(url string) bool {
 	// Skip common binary file extensions
 	binaryExtensions := []string{
 		".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".svg",
-		".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx",
+		".doc", ".xls", ".ppt",
 		".zip", ".rar", ".tar", ".gz", ".7z",
 		".mp3", ".mp4", ".wav", ".avi", ".mov", ".wmv",
 		".css", ".js", ".ico", ".xml", ".json", ".php",
@@ -826,36 +1111,19 @@ func (c *Crawler) shouldSkipURL(url string) bool {
 	return false
 }
 
-func (c *Crawler) isHTMLContent(contentType string) bool {
-	if contentType == "" {
-		return true // Assume HTML if no content type
-	}
-
-	lowerType := strings.ToLower(contentType)
-	htmlTypes := []string{
-		"text/html",
-		"application/xhtml+xml",
-		"text/plain",
-	}
-
-	for _, htmlType := range htmlTypes {
-		if strings.Contains(lowerType, htmlType) {
-			return true
-		}
-	}
-
-	return false
-}
-
 func (c *Crawler) addToSeen(url string) {
 	if c == nil || c.Mu == nil || c.VisitedUrls == nil {
 		log.Printf("ERROR: Cannot add to seen - crawler components are nil")
 		return
 	}
 
-	c.Mu.Lock()
-	defer c.Mu.Unlock()
-	c.VisitedUrls[url] = struct{}{}
+	if err := c.VisitedUrls.Add(url); err != nil {
+		log.Printf("Failed to mark %s as seen: %v", url, err)
+	}
+
+	if err := db.GetPostgresHandler().UpdateFrontierStatus(url, db.FrontierDone); err != nil {
+		log.Printf("Failed to mark %s as done: %v", url, err)
+	}
 }
 
 func appendLog(logLine string) {
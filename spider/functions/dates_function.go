@@ -0,0 +1,181 @@
+package functions
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/froxy/models"
+	"github.com/froxy/utils"
+	"golang.org/x/net/html"
+)
+
+// Publication dates and authors, from the most to the least reliable source:
+// schema.org data (JSON-LD, microdata, article:* tags), meta tags, <time> elements, then the url.
+
+var publishedMetaNames = map[string]bool{
+	"date": true, "pubdate": true, "publishdate": true, "publish-date": true, "publish_date": true,
+	"dc.date": true, "dc.date.issued": true, "dc.date.created": true, "dcterms.created": true,
+	"dcterms.issued": true, "citation_publication_date": true, "citation_date": true,
+	"sailthru.date": true, "parsely-pub-date": true, "article.published": true, "og:published_time": true,
+}
+
+var modifiedMetaNames = map[string]bool{
+	"dc.date.modified": true, "dcterms.modified": true, "last-modified": true,
+	"article.updated": true, "og:updated_time": true,
+}
+
+var (
+	publishedClass = regexp.MustCompile(`(?i)publish|posted|pubdate|entry-date|post-date|dateline`)
+	modifiedClass  = regexp.MustCompile(`(?i)updated|modified`)
+	bylineClass    = regexp.MustCompile(`(?i)(^|[\s_-])(author|byline)([\s_-]|$)`)
+	// /2024/03/15/, /2024-03-15-title, /20240315/ and /2024/03/
+	urlDatePattern = regexp.MustCompile(`/((?:19|20)\d{2})[/-](0[1-9]|1[0-2])(?:[/-](0[1-9]|[12]\d|3[01]))?(?:[/-]|$)|/((?:19|20)\d{2})(0[1-9]|1[0-2])([0-2]\d|3[01])/`)
+	bylinePrefix   = regexp.MustCompile(`(?i)^(by|written by|posted by|author:?)\s+`)
+)
+
+const (
+	// longer text in an author element is a bio, not a name
+	maxAuthorLength = 80
+	maxAuthors      = 10
+)
+
+// the web did not publish much before this
+var earliestPublication = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type dateCandidates struct {
+	published time.Time
+	modified  time.Time
+	authors   []string
+}
+
+func (d *dateCandidates) setPublished(value string) {
+	if d.published.IsZero() {
+		d.published = plausibleDate(value)
+	}
+}
+
+func (d *dateCandidates) setModified(value string) {
+	if d.modified.IsZero() {
+		d.modified = plausibleDate(value)
+	}
+}
+
+func (d *dateCandidates) addAuthor(name string) {
+	name = bylinePrefix.ReplaceAllString(normalizeSpace(name), "")
+	if name == "" || len(name) > maxAuthorLength || len(d.authors) >= maxAuthors ||
+		strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+		return
+	}
+	for _, existing := range d.authors {
+		if strings.EqualFold(existing, name) {
+			return
+		}
+	}
+	d.authors = append(d.authors, name)
+}
+
+// plausibleDate parses a date and drops the ones that cannot be a publication date
+func plausibleDate(value string) time.Time {
+	parsed, ok := utils.ParseDate(value)
+	if !ok || parsed.Before(earliestPublication) || parsed.After(time.Now().Add(24*time.Hour)) {
+		return time.Time{}
+	}
+	return parsed.UTC()
+}
+
+// extractDates fills the publication dates and authors of the page, it runs after the structured data extraction
+func (c *Crawler) extractDates(doc *html.Node, pageData *models.PageData) {
+	candidates := &dateCandidates{}
+
+	structured := pageData.StructuredData
+	candidates.setPublished(structured.DatePublished)
+	candidates.setModified(structured.DateModified)
+	for _, author := range structured.Authors {
+		candidates.addAuthor(author)
+	}
+
+	var firstTime string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "script", "style", "noscript":
+				return
+
+			case "meta":
+				key := strings.ToLower(c.getAttributeValue(n, "name"))
+				if key == "" {
+					key = strings.ToLower(c.getAttributeValue(n, "property"))
+				}
+				content := c.getAttributeValue(n, "content")
+				switch {
+				case publishedMetaNames[key]:
+					candidates.setPublished(content)
+				case modifiedMetaNames[key]:
+					candidates.setModified(content)
+				case key == "author" || key == "dc.creator" || key == "citation_author":
+					candidates.addAuthor(content)
+				}
+
+			case "time":
+				value := c.getAttributeValue(n, "datetime")
+				if value == "" {
+					value = c.extractTextContent(n)
+				}
+				class := c.getAttributeValue(n, "class") + " " + c.getAttributeValue(n, "itemprop")
+				switch {
+				case c.hasAttribute(n, "pubdate") || publishedClass.MatchString(class):
+					candidates.setPublished(value)
+				case modifiedClass.MatchString(class):
+					candidates.setModified(value)
+				case firstTime == "":
+					firstTime = value
+				}
+
+			case "a", "link":
+				if strings.Contains(strings.ToLower(c.getAttributeValue(n, "rel")), "author") {
+					candidates.addAuthor(c.extractTextContent(n))
+				}
+			}
+
+			// bylines, only when nothing better named the authors
+			if len(candidates.authors) == 0 && n.Data != "meta" && n.Data != "a" &&
+				bylineClass.MatchString(c.getAttributeValue(n, "class")) && !c.isBoilerplateNode(n) {
+				candidates.addAuthor(c.extractTextContent(n))
+			}
+		}
+
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	// an unlabeled <time> is most often the date of the article on blogs
+	candidates.setPublished(firstTime)
+	if candidates.published.IsZero() {
+		candidates.published = urlDate(pageData.URL)
+	}
+
+	pageData.PublishedAt = candidates.published
+	pageData.ModifiedAt = candidates.modified
+	pageData.Authors = candidates.authors
+}
+
+// urlDate reads dates news sites put in their paths, a missing day means the first of the month
+func urlDate(rawURL string) time.Time {
+	match := urlDatePattern.FindStringSubmatch(urlPath(rawURL))
+	if match == nil {
+		return time.Time{}
+	}
+
+	year, month, day := match[1], match[2], match[3]
+	if year == "" {
+		year, month, day = match[4], match[5], match[6]
+	}
+	if day == "" {
+		day = "01"
+	}
+	return plausibleDate(year + "-" + month + "-" + day)
+}
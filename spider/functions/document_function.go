@@ -0,0 +1,175 @@
+package functions
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/froxy/models"
+	"github.com/ledongthuc/pdf"
+)
+
+// Kinds of documents the spider can read
+const (
+	documentHTML = "html"
+	documentPDF  = "pdf"
+	documentDOCX = "docx"
+	documentPPTX = "pptx"
+	documentXLSX = "xlsx"
+)
+
+var (
+	maxHTMLBytes int64 = 10 * 1024 * 1024 // 10MB limit
+	// whitepapers and slide decks are a lot heavier than pages
+	maxDocumentBytes int64 = 50 * 1024 * 1024
+)
+
+var documentContentTypes = map[string]string{
+	"text/html":             documentHTML,
+	"application/xhtml+xml": documentHTML,
+	"text/plain":            documentHTML,
+	"application/pdf":       documentPDF,
+	"application/x-pdf":     documentPDF,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   documentDOCX,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": documentPPTX,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         documentXLSX,
+}
+
+var documentExtensions = map[string]string{
+	".pdf":  documentPDF,
+	".docx": documentDOCX,
+	".pptx": documentPPTX,
+	".xlsx": documentXLSX,
+}
+
+// documentKind picks the extractor for a response, an empty kind means the content is not supported.
+// Servers often send documents as application/octet-stream, the url extension decides then.
+func (c *Crawler) documentKind(contentType, rawURL string) string {
+	if contentType == "" {
+		return documentHTML // Assume HTML if no content type
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	if kind, ok := documentContentTypes[mediaType]; ok {
+		return kind
+	}
+
+	if mediaType == "application/octet-stream" || mediaType == "binary/octet-stream" || mediaType == "application/zip" {
+		return documentExtensions[strings.ToLower(path.Ext(urlPath(rawURL)))]
+	}
+	return ""
+}
+
+// extractDocumentData reads a PDF or OOXML document into the same PageData an HTML page produces
+func (c *Crawler) extractDocumentData(kind string, body []byte, url string, resp *http.Response, responseTime time.Duration) (*models.PageData, error) {
+	pageData := &models.PageData{
+		URL:           url,
+		Headings:      make(map[string][]string),
+		ImageAlt:      make([]string, 0),
+		LinkText:      make([]string, 0),
+		OutboundLinks: make([]models.Link, 0),
+		StatusCode:    resp.StatusCode,
+		ResponseTime:  responseTime,
+		ContentType:   resp.Header.Get("Content-Type"),
+		CrawlDate:     time.Now(),
+		Encoding:      "utf-8",
+	}
+
+	if lastMod := resp.Header.Get("Last-Modified"); lastMod != "" {
+		if parsed, err := http.ParseTime(lastMod); err == nil {
+			pageData.LastModified = parsed
+		}
+	}
+	pageData.ETag = resp.Header.Get("ETag")
+
+	var err error
+	switch kind {
+	case documentPDF:
+		err = c.extractPDF(body, pageData)
+	case documentDOCX:
+		err = c.extractDOCX(body, pageData)
+	case documentPPTX:
+		err = c.extractPPTX(body, pageData)
+	case documentXLSX:
+		err = c.extractXLSX(body, pageData)
+	default:
+		err = fmt.Errorf("unsupported document kind %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pageData.MainContent = joinBlocks(pageData.Blocks)
+	if pageData.Title == "" {
+		pageData.Title = documentTitle(pageData.Blocks, url)
+	}
+	pageData.WordCount = len(strings.Fields(pageData.MainContent))
+	pageData.Language = detectPageLanguage(pageData)
+
+	return pageData, nil
+}
+
+// extractPDF keeps the text of every page as a paragraph block, the title comes from the info dictionary
+func (c *Crawler) extractPDF(body []byte, pageData *models.PageData) (err error) {
+	// the pdf reader panics on some malformed files instead of returning an error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed pdf: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return fmt.Errorf("failed to open pdf: %w", err)
+	}
+
+	pageData.Title = normalizeSpace(reader.Trailer().Key("Info").Key("Title").Text())
+	pageData.PageCount = reader.NumPage()
+
+	for i := 1; i <= pageData.PageCount; i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			// one unreadable page should not lose the rest of the document
+			continue
+		}
+		if text = normalizeSpace(text); text != "" {
+			pageData.Blocks = append(pageData.Blocks, models.ContentBlock{Type: models.BlockParagraph, Text: text})
+		}
+	}
+
+	if len(pageData.Blocks) == 0 {
+		return fmt.Errorf("no text found in pdf (scanned document?)")
+	}
+	return nil
+}
+
+// documentTitle falls back to the first heading, then to the file name
+func documentTitle(blocks []models.ContentBlock, rawURL string) string {
+	for _, block := range blocks {
+		if block.Type == models.BlockHeading {
+			return block.Text
+		}
+	}
+
+	name := path.Base(urlPath(rawURL))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	return strings.NewReplacer("-", " ", "_", " ").Replace(name)
+}
+
+func urlPath(rawURL string) string {
+	if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+		rawURL = rawURL[:i]
+	}
+	return rawURL
+}
@@ -0,0 +1,94 @@
+package functions
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/froxy/db"
+	"github.com/froxy/models"
+)
+
+const (
+	fetchLogBatchSize     = 200
+	fetchLogFlushInterval = 10 * time.Second
+	// attempts kept in memory while the database cannot take them, the older ones are dropped past that
+	maxPendingFetchAttempts = 10000
+)
+
+// fetchLog buffers the fetch attempts of the workers and writes them to the fetch_attempts table in batches.
+// The writes happen in run, a worker never waits on the database.
+type fetchLog struct {
+	mu      sync.Mutex
+	pending []models.FetchAttempt
+	// set while the database fails the writes, the next try waits for the ticker
+	failing bool
+	// tells run a batch is full
+	full chan struct{}
+}
+
+func newFetchLog() *fetchLog {
+	return &fetchLog{full: make(chan struct{}, 1)}
+}
+
+func (l *fetchLog) add(attempt models.FetchAttempt) {
+	l.mu.Lock()
+	l.pending = append(l.pending, attempt)
+	full := len(l.pending) >= fetchLogBatchSize && !l.failing
+	l.mu.Unlock()
+
+	if full {
+		select {
+		case l.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (l *fetchLog) flush() {
+	l.mu.Lock()
+	batch := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := db.GetPostgresHandler().RecordFetchAttempts(batch); err != nil {
+		log.Printf("Failed to write %d fetch attempts: %v", len(batch), err)
+
+		// keep them for the next flush, the database may be back by then
+		l.mu.Lock()
+		l.pending = append(batch, l.pending...)
+		if dropped := len(l.pending) - maxPendingFetchAttempts; dropped > 0 {
+			l.pending = l.pending[dropped:]
+			log.Printf("Dropped %d fetch attempts", dropped)
+		}
+		l.failing = true
+		l.mu.Unlock()
+		return
+	}
+
+	l.mu.Lock()
+	l.failing = false
+	l.mu.Unlock()
+}
+
+// run flushes the attempts at regular intervals, and as soon as a batch is full, until the context is done
+func (l *fetchLog) run(ctx context.Context) {
+	ticker := time.NewTicker(fetchLogFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.flush()
+		case <-l.full:
+			l.flush()
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/froxy/config"
+)
+
+const (
+	// resolved hosts kept by the DNS cache, it starts over past that
+	maxDNSCacheEntries = 100000
+	// redirects followed for a request without its own limit, as net/http does
+	maxRedirects = 10
+)
+
+var (
+	fetchConfig = config.Default().Fetch
+	// shared by the transports of every route, Configure replaces it
+	resolver = newDNSCache(fetchConfig.DNSCacheTTL)
+)
+
+// Fetcher sends every request of the crawler: pages, robots.txt and sitemaps. The requests go through
+// the proxy pool, which keeps one long-lived transport per route (direct or a proxy), so connections
+// and HTTP/2 sessions are reused across pages and workers.
+type Fetcher struct {
+	client *http.Client
+}
+
+// FetchTiming measures a request
+type FetchTiming struct {
+	// until the headers of the final response
+	ResponseTime time.Duration
+	// from asking for a connection to the first byte of the final response, dns, connect and tls included
+	TimeToFirstByte time.Duration
+}
+
+func NewFetcher(transport http.RoundTripper) *Fetcher {
+	// no client timeout, the phases have their own and Do bounds the request and the body separately
+	return &Fetcher{client: &http.Client{Transport: transport, CheckRedirect: checkRedirect}}
+}
+
+type redirectLimitKey struct{}
+
+// withRedirectLimit makes the fetcher stop after limit redirects and return the last 3xx response
+// instead of an error
+func withRedirectLimit(ctx context.Context, limit int) context.Context {
+	return context.WithValue(ctx, redirectLimitKey{}, limit)
+}
+
+func checkRedirect(request *http.Request, via []*http.Request) error {
+	if limit, ok := request.Context().Value(redirectLimitKey{}).(int); ok {
+		if len(via) > limit {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	return nil
+}
+
+// Do sends the request. requestTimeout bounds it until the headers of the final response, redirects
+// included. The response body must be closed, it has BodyTimeout to be read.
+func (f *Fetcher) Do(request *http.Request) (*http.Response, FetchTiming, error) {
+	ctx, cancel := context.WithCancel(request.Context())
+	trace := &hopTrace{}
+	ctx = httptrace.WithClientTrace(ctx, trace.clientTrace())
+
+	var timedOut atomic.Bool
+	responseTimer := time.AfterFunc(requestTimeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+
+	startTime := time.Now()
+	resp, err := f.client.Do(request.WithContext(ctx))
+	timing := FetchTiming{ResponseTime: time.Since(startTime), TimeToFirstByte: trace.firstByte()}
+	responseTimer.Stop()
+	if err == nil && timedOut.Load() {
+		// the headers came in just as the timer fired, the body is lost already
+		resp.Body.Close()
+		err = context.Canceled
+	}
+	if err != nil {
+		cancel()
+		if timedOut.Load() {
+			err = fmt.Errorf("no response within %v: %w", requestTimeout, context.DeadlineExceeded)
+		}
+		return nil, timing, err
+	}
+
+	body := &timedBody{ReadCloser: resp.Body, cancel: cancel, timeout: fetchConfig.BodyTimeout}
+	body.timer = time.AfterFunc(fetchConfig.BodyTimeout, func() {
+		body.expired.Store(true)
+		cancel()
+	})
+	resp.Body = body
+	return resp, timing, nil
+}
+
+// hopTrace times the last hop of a request, redirects start a new one
+type hopTrace struct {
+	mu    sync.Mutex
+	start time.Time
+	ttfb  time.Duration
+}
+
+func (t *hopTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.mu.Lock()
+			t.start = time.Now()
+			t.ttfb = 0
+			t.mu.Unlock()
+		},
+		// http2 calls it from its read loop
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.ttfb = time.Since(t.start)
+			t.mu.Unlock()
+		},
+	}
+}
+
+func (t *hopTrace) firstByte() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ttfb
+}
+
+// timedBody cancels the request when the body is not read within its timeout
+type timedBody struct {
+	io.ReadCloser
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	timeout time.Duration
+	expired atomic.Bool
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.expired.Load() {
+		err = fmt.Errorf("body not read within %v: %w", b.timeout, context.DeadlineExceeded)
+	}
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// routeDialer opens the connections of a route, to the site or to its proxy
+func routeDialer() contextDialer {
+	dialer := &net.Dialer{Timeout: fetchConfig.DialTimeout, KeepAlive: 30 * time.Second}
+	return resolver.dialContext(dialer)
+}
+
+func newTransport() *http.Transport {
+	return &http.Transport{
+		DialContext: routeDialer(),
+		// a custom dialer turns HTTP/2 off unless it is asked for
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   fetchConfig.TLSTimeout,
+		ResponseHeaderTimeout: fetchConfig.HeaderTimeout,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+}
+
+// dnsCache remembers the addresses of the hosts for a while, a crawl resolves the same hosts over and over
+type dnsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	return &dnsCache{ttl: ttl, entries: make(map[string]dnsEntry)}
+}
+
+// lookup resolves the host, IPv4 addresses first. Failures are not cached.
+func (d *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	d.mu.Lock()
+	entry, ok := d.entries[host]
+	d.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return net.ParseIP(addrs[i]).To4() != nil && net.ParseIP(addrs[j]).To4() == nil
+	})
+
+	d.mu.Lock()
+	if len(d.entries) >= maxDNSCacheEntries {
+		d.entries = make(map[string]dnsEntry)
+	}
+	d.entries[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(d.ttl)}
+	d.mu.Unlock()
+	return addrs, nil
+}
+
+// dialContext dials the addresses of the host in turn, resolved through the cache
+func (d *dnsCache) dialContext(dialer *net.Dialer) contextDialer {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || d.ttl <= 0 || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		addrs, err := d.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var lastErr error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, lastErr
+	}
+}
+
+// contextDialer adapts a dial function to the proxy package, SOCKS proxies are reached through it
+type contextDialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (d contextDialer) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d contextDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
@@ -0,0 +1,136 @@
+package functions
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/froxy/config"
+	"github.com/froxy/models"
+	"github.com/froxy/utils"
+)
+
+const (
+	// anchors embedded per page, the other links only get the relevance of the page
+	maxAnchorsPerPage = 50
+	// "next", "read more" and friends come back on every page, their relevance is cached
+	maxCachedAnchors = 50000
+	// how much the relevance of a link weighs in the frontier score
+	relevanceWeight = 2.0
+)
+
+// topicFocus scores pages and links against the topic of a focused crawl
+type topicFocus struct {
+	config  config.FocusConfig
+	vector  []float32
+	mu      sync.Mutex
+	anchors map[string]float64
+}
+
+// Focus embeds the topic and turns the crawl into a focused one, it must be called before starting
+func (c *Crawler) Focus(focusConfig config.FocusConfig) error {
+	embedding, err := utils.Embed(focusConfig.Topic)
+	if err != nil {
+		return fmt.Errorf("failed to embed the topic: %w", err)
+	}
+	if len(embedding.Embedding) == 0 {
+		return fmt.Errorf("failed to embed the topic: empty embedding")
+	}
+
+	c.focus = &topicFocus{
+		config:  focusConfig,
+		vector:  embedding.Embedding,
+		anchors: make(map[string]float64),
+	}
+	log.Printf("Focused crawl on %q, links below %.2f are not followed", focusConfig.Topic, focusConfig.Threshold)
+	appendLog(fmt.Sprintf("Focused crawl on %q, links below %.2f are not followed", focusConfig.Topic, focusConfig.Threshold))
+	return nil
+}
+
+// pageRelevance embeds the page and compares it to the topic, the embedding is kept for the Qdrant upsert
+func (f *topicFocus) pageRelevance(pageData *models.PageData) (float64, error) {
+	text := pageData.EmbeddingText()
+	if strings.TrimSpace(text) == "" {
+		text = pageData.Title + "\n" + pageData.MetaDescription
+	}
+
+	embedding, err := utils.Embed(text)
+	if err != nil {
+		return 0, err
+	}
+	pageData.Embedding = embedding.Embedding
+	return cosineSimilarity(f.vector, embedding.Embedding), nil
+}
+
+// anchorRelevance compares an anchor text to the topic
+func (f *topicFocus) anchorRelevance(text string) (float64, error) {
+	key := strings.ToLower(text)
+
+	f.mu.Lock()
+	relevance, cached := f.anchors[key]
+	f.mu.Unlock()
+	if cached {
+		return relevance, nil
+	}
+
+	embedding, err := utils.Embed(text)
+	if err != nil {
+		return 0, err
+	}
+	relevance = cosineSimilarity(f.vector, embedding.Embedding)
+
+	f.mu.Lock()
+	if len(f.anchors) >= maxCachedAnchors {
+		f.anchors = make(map[string]float64)
+	}
+	f.anchors[key] = relevance
+	f.mu.Unlock()
+	return relevance, nil
+}
+
+// scoreLinks sets the relevance of the outbound links of a page and reports how relevant the page itself is.
+// Links without an anchor text, or past maxAnchorsPerPage, get the relevance of the page.
+func (f *topicFocus) scoreLinks(pageData *models.PageData, links []models.Link) (float64, error) {
+	pageRelevance, err := f.pageRelevance(pageData)
+	if err != nil {
+		return 0, err
+	}
+
+	embedded := 0
+	for i := range links {
+		links[i].Relevance = pageRelevance
+
+		text := strings.TrimSpace(links[i].Text)
+		if text == "" || embedded >= maxAnchorsPerPage {
+			continue
+		}
+		embedded++
+
+		anchorRelevance, err := f.anchorRelevance(text)
+		if err != nil {
+			log.Printf("Failed to embed anchor %q: %v", text, err)
+			continue
+		}
+		links[i].Relevance = f.config.AnchorWeight*anchorRelevance + (1-f.config.AnchorWeight)*pageRelevance
+	}
+	return pageRelevance, nil
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,242 @@
+package functions
+
+import (
+	"container/heap"
+	"math"
+	"time"
+
+	"github.com/froxy/config"
+	"github.com/froxy/models"
+)
+
+// Weights of the frontier score. A link scores higher with a higher sitemap priority and with more
+// pages linking to it, lower the further it is from its seed. Hosts lose a little for every page
+// already taken from them, so one big site does not starve the others.
+const (
+	priorityWeight = 1.0
+	inlinkWeight   = 0.5
+	depthWeight    = 0.25
+	fairnessWeight = 0.5
+	// sitemap priority of the links found in pages
+	defaultLinkPriority = 0.5
+)
+
+// links kept in memory, past that new links only go to crawl_frontier and are loaded back when there is room
+var frontierWindow = config.Default().FrontierWindow
+
+// linkScore ranks a queued link against the other links of its host, in a focused crawl relevance weighs the most
+func linkScore(link models.Link) float64 {
+	priority := link.Priority
+	if priority == 0 {
+		priority = defaultLinkPriority
+	}
+	return priority*priorityWeight + math.Log1p(float64(link.Inlinks))*inlinkWeight - float64(link.Depth)*depthWeight +
+		link.Relevance*relevanceWeight
+}
+
+// priorityHeap is a binary heap whose items know their position, so they can be fixed in O(log n)
+type priorityHeap[T any] struct {
+	items    []T
+	less     func(a, b T) bool
+	setIndex func(item T, index int)
+}
+
+func (h *priorityHeap[T]) Len() int           { return len(h.items) }
+func (h *priorityHeap[T]) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+
+func (h *priorityHeap[T]) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.setIndex(h.items[i], i)
+	h.setIndex(h.items[j], j)
+}
+
+func (h *priorityHeap[T]) Push(item any) {
+	h.setIndex(item.(T), len(h.items))
+	h.items = append(h.items, item.(T))
+}
+
+func (h *priorityHeap[T]) Pop() any {
+	last := len(h.items) - 1
+	item := h.items[last]
+	var zero T
+	h.items[last] = zero
+	h.items = h.items[:last]
+	h.setIndex(item, -1)
+	return item
+}
+
+type frontierEntry struct {
+	link  models.Link
+	score float64
+	seq   uint64 // enqueue order, the oldest wins a tie
+	index int
+}
+
+// host states in the frontier
+const (
+	hostIdle    = iota // no queued link
+	hostReady          // in the ready heap
+	hostWaiting        // in the waiting heap until readyAt
+	hostActive         // a worker is fetching it
+)
+
+type hostQueue struct {
+	host    string
+	links   *priorityHeap[*frontierEntry]
+	crawled int
+	state   int
+	readyAt time.Time
+	index   int
+}
+
+func (h *hostQueue) score() float64 {
+	return h.links.items[0].score - math.Log1p(float64(h.crawled))*fairnessWeight
+}
+
+// Frontier orders the queued links: per host by score, and across hosts by the score of their best
+// link among the hosts the scheduler lets us fetch. It is guarded by the crawler mutex.
+type Frontier struct {
+	entries map[string]*frontierEntry
+	hosts   map[string]*hostQueue
+	ready   *priorityHeap[*hostQueue]
+	waiting *priorityHeap[*hostQueue]
+	seq     uint64
+	// some links were only written to crawl_frontier (status spilled)
+	spilled bool
+}
+
+func NewFrontier() *Frontier {
+	setHostIndex := func(h *hostQueue, index int) { h.index = index }
+	return &Frontier{
+		entries: make(map[string]*frontierEntry),
+		hosts:   make(map[string]*hostQueue),
+		ready: &priorityHeap[*hostQueue]{
+			less:     func(a, b *hostQueue) bool { return a.score() > b.score() },
+			setIndex: setHostIndex,
+		},
+		waiting: &priorityHeap[*hostQueue]{
+			less:     func(a, b *hostQueue) bool { return a.readyAt.Before(b.readyAt) },
+			setIndex: setHostIndex,
+		},
+	}
+}
+
+func (f *Frontier) Len() int {
+	return len(f.entries)
+}
+
+func (f *Frontier) Contains(url string) bool {
+	_, queued := f.entries[url]
+	return queued
+}
+
+// Full reports whether the in-memory window is used up
+func (f *Frontier) Full() bool {
+	return len(f.entries) >= frontierWindow
+}
+
+// Push queues a link that is not queued yet
+func (f *Frontier) Push(link models.Link) {
+	host := hostOf(link.URL)
+	queue, ok := f.hosts[host]
+	if !ok {
+		queue = &hostQueue{
+			host: host,
+			links: &priorityHeap[*frontierEntry]{
+				less: func(a, b *frontierEntry) bool {
+					if a.score != b.score {
+						return a.score > b.score
+					}
+					return a.seq < b.seq
+				},
+				setIndex: func(e *frontierEntry, index int) { e.index = index },
+			},
+			index: -1,
+		}
+		f.hosts[host] = queue
+	}
+
+	f.seq++
+	entry := &frontierEntry{link: link, score: linkScore(link), seq: f.seq}
+	f.entries[link.URL] = entry
+	heap.Push(queue.links, entry)
+
+	switch queue.state {
+	case hostIdle:
+		// the scheduler decides when it is really ready, see Pop
+		queue.state = hostWaiting
+		queue.readyAt = time.Time{}
+		heap.Push(f.waiting, queue)
+	case hostReady:
+		heap.Fix(f.ready, queue.index)
+	}
+}
+
+// AddInlink counts one more page linking to a queued url
+func (f *Frontier) AddInlink(url string) {
+	entry, ok := f.entries[url]
+	if !ok {
+		return
+	}
+
+	entry.link.Inlinks++
+	entry.score = linkScore(entry.link)
+
+	queue := f.hosts[hostOf(url)]
+	heap.Fix(queue.links, entry.index)
+	if queue.state == hostReady {
+		heap.Fix(f.ready, queue.index)
+	}
+}
+
+// Pop takes the best link of the best host the scheduler lets us fetch now and marks the host active.
+// When no host is ready it returns false with the time until one should be.
+func (f *Frontier) Pop(scheduler *HostScheduler, now time.Time) (models.Link, time.Duration, bool) {
+	for f.waiting.Len() > 0 && !f.waiting.items[0].readyAt.After(now) {
+		queue := heap.Pop(f.waiting).(*hostQueue)
+		queue.state = hostReady
+		heap.Push(f.ready, queue)
+	}
+
+	for f.ready.Len() > 0 {
+		queue := heap.Pop(f.ready).(*hostQueue)
+		if !scheduler.Acquire(queue.host, now) {
+			queue.state = hostWaiting
+			queue.readyAt = now.Add(max(scheduler.ReadyIn(queue.host, now), time.Millisecond))
+			heap.Push(f.waiting, queue)
+			continue
+		}
+
+		entry := heap.Pop(queue.links).(*frontierEntry)
+		delete(f.entries, entry.link.URL)
+		queue.crawled++
+		queue.state = hostActive
+		return entry.link, 0, true
+	}
+
+	if f.waiting.Len() > 0 {
+		return models.Link{}, max(f.waiting.items[0].readyAt.Sub(now), time.Millisecond), false
+	}
+	if len(f.entries) > 0 {
+		// the links left belong to hosts being fetched right now
+		return models.Link{}, max(timesleep, 100*time.Millisecond), false
+	}
+	return models.Link{}, 0, false
+}
+
+// Release puts the host back once its fetch is over, it waits for the scheduler delay
+func (f *Frontier) Release(host string, readyIn time.Duration) {
+	queue, ok := f.hosts[host]
+	if !ok || queue.state != hostActive {
+		return
+	}
+
+	// a host with nothing queued is forgotten, a crawl sees far more hosts than it has queued at once
+	if queue.links.Len() == 0 {
+		delete(f.hosts, host)
+		return
+	}
+	queue.state = hostWaiting
+	queue.readyAt = time.Now().Add(readyIn)
+	heap.Push(f.waiting, queue)
+}
@@ -0,0 +1,162 @@
+package functions
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// robots.txt files sometimes ask for absurd delays, we never wait longer than this between two fetches
+const maxCrawlDelay = time.Minute
+
+// a struggling host is left alone for at most this long, whatever it answers
+const maxHostBackoff = 10 * time.Minute
+
+// HostScheduler enforces politeness per host instead of per worker.
+// A host is fetched by at most one worker at a time, and the next fetch
+// only starts after the host delay (our minimum or the robots.txt Crawl-delay) has passed.
+type HostScheduler struct {
+	mu          sync.Mutex
+	minDelay    time.Duration
+	crawlDelays map[string]time.Duration
+	nextAllowed map[string]time.Time
+	active      map[string]bool
+	// hosts that are rate limiting us or failing, and how many failures in a row
+	backoffUntil map[string]time.Time
+	failures     map[string]int
+}
+
+func NewHostScheduler(minDelay time.Duration) *HostScheduler {
+	return &HostScheduler{
+		minDelay:     minDelay,
+		crawlDelays:  make(map[string]time.Duration),
+		nextAllowed:  make(map[string]time.Time),
+		active:       make(map[string]bool),
+		backoffUntil: make(map[string]time.Time),
+		failures:     make(map[string]int),
+	}
+}
+
+// SetCrawlDelay records the Crawl-delay requested by the host robots.txt
+func (s *HostScheduler) SetCrawlDelay(host string, delay time.Duration) {
+	if delay > maxCrawlDelay {
+		delay = maxCrawlDelay
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.crawlDelays[host] = delay
+}
+
+// Delay returns the minimum time between two fetches of the host
+func (s *HostScheduler) Delay(host string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delayLocked(host)
+}
+
+func (s *HostScheduler) delayLocked(host string) time.Duration {
+	if delay := s.crawlDelays[host]; delay > s.minDelay {
+		return delay
+	}
+	return s.minDelay
+}
+
+// ReadyIn reports how long we still have to wait before the host can be fetched, zero means now
+func (s *HostScheduler) ReadyIn(host string, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active[host] {
+		// someone is fetching it, the earliest we can go is one delay from now
+		return s.delayLocked(host)
+	}
+
+	if next := s.nextAllowedLocked(host); now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// nextAllowedLocked is the earliest time the host can be fetched again, the caller must hold s.mu
+func (s *HostScheduler) nextAllowedLocked(host string) time.Time {
+	next := s.nextAllowed[host]
+	if backoff := s.backoffUntil[host]; backoff.After(next) {
+		return backoff
+	}
+	return next
+}
+
+// Acquire marks the host as being fetched, it returns false if the host is not ready yet
+func (s *HostScheduler) Acquire(host string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active[host] {
+		return false
+	}
+	if now.Before(s.nextAllowedLocked(host)) {
+		return false
+	}
+
+	s.active[host] = true
+	return true
+}
+
+// Release frees the host after a fetch and starts its cool down
+func (s *HostScheduler) Release(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.active, host)
+	s.nextAllowed[host] = time.Now().Add(s.delayLocked(host))
+}
+
+// Backoff keeps the host away for at least the delay, e.g. the Retry-After of a 429 or a 503
+func (s *HostScheduler) Backoff(host string, delay time.Duration) {
+	if delay > maxHostBackoff {
+		delay = maxHostBackoff
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if until := time.Now().Add(delay); until.After(s.backoffUntil[host]) {
+		s.backoffUntil[host] = until
+	}
+}
+
+// Failure records a server side failure (5xx, timeout, refused connection).
+// Each failure in a row doubles the time the host is left alone, it returns that time.
+func (s *HostScheduler) Failure(host string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[host]++
+	delay := s.delayLocked(host) << min(s.failures[host], 16)
+	if delay <= 0 || delay > maxHostBackoff {
+		delay = maxHostBackoff
+	}
+
+	if until := time.Now().Add(delay); until.After(s.backoffUntil[host]) {
+		s.backoffUntil[host] = until
+	}
+	return delay
+}
+
+// Success clears the failures of the host
+func (s *HostScheduler) Success(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.failures, host)
+	delete(s.backoffUntil, host)
+}
+
+func hostOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
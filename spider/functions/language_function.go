@@ -0,0 +1,16 @@
+package functions
+
+import (
+	"github.com/froxy/models"
+	"github.com/froxy/utils"
+)
+
+// detectPageLanguage trusts the text over the declared language, many sites keep the lang
+// attribute of their template on translated pages. The declared one (html lang, then meta)
+// is only used when the text is too short or mixed to tell.
+func detectPageLanguage(pageData *models.PageData) string {
+	if language, _ := utils.DetectLanguage(pageData.MainContent); language != "" {
+		return language
+	}
+	return utils.NormalizeLanguage(pageData.Language)
+}
@@ -0,0 +1,267 @@
+package functions
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	codeLanguageClass    = regexp.MustCompile(`(?:^|\s)(?:language|lang)-([\w+#-]+)`)
+	markdownControlChars = regexp.MustCompile(`[\x00-\x08\x0B\x0C\x0E-\x1F\x7F]`)
+)
+
+// markdownWriter renders the main content nodes as Markdown so headings, lists, tables
+// and code blocks keep their structure for the embedding and the retrieved chunks
+type markdownWriter struct {
+	crawler *Crawler
+	blocks  []string
+}
+
+// renderMarkdown converts the main content nodes to Markdown
+func (c *Crawler) renderMarkdown(nodes []*html.Node) string {
+	writer := &markdownWriter{crawler: c, blocks: make([]string, 0)}
+	for _, n := range nodes {
+		writer.block(n, 0)
+	}
+
+	// code blocks keep their indentation, so only invalid and control characters are dropped here
+	markdown := strings.ToValidUTF8(strings.Join(writer.blocks, "\n\n"), "")
+	return markdownControlChars.ReplaceAllString(markdown, "")
+}
+
+func (w *markdownWriter) add(block string) {
+	block = strings.TrimRight(block, " \n")
+	if strings.TrimSpace(block) != "" {
+		w.blocks = append(w.blocks, block)
+	}
+}
+
+// block renders a block level node, inline runs between blocks become paragraphs
+func (w *markdownWriter) block(n *html.Node, listDepth int) {
+	c := w.crawler
+
+	if n.Type == html.TextNode {
+		w.add(normalizeSpace(n.Data))
+		return
+	}
+	if n.Type != html.ElementNode {
+		w.children(n, listDepth)
+		return
+	}
+	if c.isBoilerplateNode(n) {
+		return
+	}
+
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := int(n.Data[1] - '0')
+		w.add(strings.Repeat("#", level) + " " + w.inline(n))
+
+	case "p":
+		w.add(w.inline(n))
+
+	case "ul", "ol":
+		if c.linkDensity(n) > maxBlockLinkDensity {
+			return
+		}
+		w.add(w.list(n, listDepth))
+
+	case "pre":
+		language := ""
+		for _, node := range []*html.Node{n, n.FirstChild} {
+			if node == nil || node.Type != html.ElementNode {
+				continue
+			}
+			if match := codeLanguageClass.FindStringSubmatch(c.getAttributeValue(node, "class")); match != nil {
+				language = match[1]
+				break
+			}
+		}
+		code := strings.Trim(rawText(n), "\n")
+		w.add("```" + language + "\n" + code + "\n```")
+
+	case "blockquote":
+		quote := &markdownWriter{crawler: c, blocks: make([]string, 0)}
+		quote.children(n, listDepth)
+		lines := strings.Split(strings.Join(quote.blocks, "\n\n"), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		w.add(strings.Join(lines, "\n"))
+
+	case "table":
+		if isLayoutTable(n) {
+			w.children(n, listDepth)
+			return
+		}
+		w.add(w.table(n))
+
+	case "hr":
+		w.add("---")
+
+	default:
+		if !blockElements[n.Data] {
+			// a stray inline element between blocks
+			w.add(tidyInline(normalizeSpace(w.inlineNode(n))))
+			return
+		}
+		if c.linkDensity(n) > maxBlockLinkDensity && len(c.extractTextContent(n)) < 500 {
+			return
+		}
+		w.children(n, listDepth)
+	}
+}
+
+// children renders the children of a container, grouping consecutive inline nodes in one paragraph
+func (w *markdownWriter) children(n *html.Node, listDepth int) {
+	var paragraph strings.Builder
+	flush := func() {
+		if text := tidyInline(normalizeSpace(paragraph.String())); len(text) >= minParagraphLength {
+			w.add(text)
+		}
+		paragraph.Reset()
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && blockElements[child.Data] {
+			flush()
+			w.block(child, listDepth)
+			continue
+		}
+		paragraph.WriteString(w.inlineNode(child))
+	}
+	flush()
+}
+
+// inline renders the children of a node with their inline formatting
+func (w *markdownWriter) inline(n *html.Node) string {
+	var builder strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.writeInline(&builder, child)
+	}
+	return tidyInline(normalizeSpace(builder.String()))
+}
+
+// inlineNode renders a single inline node, its own formatting included
+func (w *markdownWriter) inlineNode(n *html.Node) string {
+	var builder strings.Builder
+	w.writeInline(&builder, n)
+	return builder.String()
+}
+
+func (w *markdownWriter) writeInline(builder *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		builder.WriteString(n.Data)
+		return
+	case html.ElementNode:
+		if w.crawler.isBoilerplateNode(n) {
+			return
+		}
+		switch n.Data {
+		case "br":
+			builder.WriteString(" ")
+			return
+		case "code", "kbd", "samp":
+			if text := strings.TrimSpace(rawText(n)); text != "" {
+				builder.WriteString(" `" + strings.ReplaceAll(text, "`", "'") + "` ")
+			}
+			return
+		case "strong", "b":
+			if text := w.inline(n); text != "" {
+				builder.WriteString(" **" + text + "** ")
+			}
+			return
+		case "em", "i":
+			if text := w.inline(n); text != "" {
+				builder.WriteString(" *" + text + "* ")
+			}
+			return
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.writeInline(builder, child)
+	}
+}
+
+// list renders ul/ol items, nested lists are indented under their item
+func (w *markdownWriter) list(n *html.Node, depth int) string {
+	lines := make([]string, 0)
+	indent := strings.Repeat("  ", depth)
+	index := 1
+
+	for item := n.FirstChild; item != nil; item = item.NextSibling {
+		if item.Type != html.ElementNode || item.Data != "li" {
+			continue
+		}
+
+		marker := "- "
+		if n.Data == "ol" {
+			marker = strconv.Itoa(index) + ". "
+			index++
+		}
+
+		var text strings.Builder
+		nested := make([]string, 0)
+		for child := item.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode && (child.Data == "ul" || child.Data == "ol") {
+				nested = append(nested, w.list(child, depth+1))
+				continue
+			}
+			text.WriteString(w.inlineNode(child))
+		}
+
+		line := tidyInline(normalizeSpace(text.String()))
+		if line == "" && len(nested) == 0 {
+			continue
+		}
+		lines = append(lines, indent+marker+line)
+		lines = append(lines, nested...)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// table renders a data table, the first row is used as the header
+func (w *markdownWriter) table(n *html.Node) string {
+	rows := tableRows(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+
+	lines := make([]string, 0, len(rows)+1)
+	for i, row := range rows {
+		cells := make([]string, columns)
+		for j := range cells {
+			if j < len(row) {
+				cells[j] = strings.ReplaceAll(w.inline(row[j]), "|", "\\|")
+			}
+		}
+		lines = append(lines, "| "+strings.Join(cells, " | ")+" |")
+
+		if i == 0 {
+			separator := make([]string, columns)
+			for j := range separator {
+				separator[j] = "---"
+			}
+			lines = append(lines, "| "+strings.Join(separator, " | ")+" |")
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+var spaceBeforePunctuation = regexp.MustCompile(` ([.,;:!?)])`)
+
+// tidyInline removes the spaces the inline markers introduced before punctuation
+func tidyInline(text string) string {
+	return spaceBeforePunctuation.ReplaceAllString(text, "$1")
+}
@@ -0,0 +1,441 @@
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/froxy/models"
+)
+
+// Office Open XML documents are zip archives of xml parts, only the parts holding text are read
+
+// a single part is never this big in a real document, bigger ones are zip bombs
+const maxOOXMLPartBytes = 20 * 1024 * 1024
+
+var (
+	headingStyle  = regexp.MustCompile(`(?i)^heading\s*([1-6])$`)
+	slidePart     = regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+	worksheetPart = regexp.MustCompile(`^xl/worksheets/sheet(\d+)\.xml$`)
+)
+
+type ooxmlDocument struct {
+	files map[string]*zip.File
+}
+
+func openOOXML(body []byte) (*ooxmlDocument, error) {
+	reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open document archive: %w", err)
+	}
+
+	document := &ooxmlDocument{files: make(map[string]*zip.File)}
+	for _, file := range reader.File {
+		document.files[file.Name] = file
+	}
+	return document, nil
+}
+
+// decoder opens a part of the archive, nil when the part does not exist
+func (d *ooxmlDocument) decoder(name string) (*xml.Decoder, func(), error) {
+	file, ok := d.files[name]
+	if !ok {
+		return nil, nil, nil
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	decoder := xml.NewDecoder(io.LimitReader(rc, maxOOXMLPartBytes))
+	decoder.Strict = false
+	return decoder, func() { rc.Close() }, nil
+}
+
+// numberedParts lists parts like slide1.xml, slide2.xml... in their numeric order
+func (d *ooxmlDocument) numberedParts(pattern *regexp.Regexp) []string {
+	type part struct {
+		name   string
+		number int
+	}
+	parts := make([]part, 0)
+	for name := range d.files {
+		if match := pattern.FindStringSubmatch(name); match != nil {
+			number, _ := strconv.Atoi(match[1])
+			parts = append(parts, part{name: name, number: number})
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].number < parts[j].number })
+
+	names := make([]string, len(parts))
+	for i, p := range parts {
+		names[i] = p.name
+	}
+	return names
+}
+
+// title reads dc:title from docProps/core.xml
+func (d *ooxmlDocument) title() string {
+	decoder, done, err := d.decoder("docProps/core.xml")
+	if err != nil || decoder == nil {
+		return ""
+	}
+	defer done()
+
+	inTitle := false
+	var title strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			inTitle = t.Name.Local == "title"
+		case xml.EndElement:
+			if t.Name.Local == "title" {
+				return normalizeSpace(title.String())
+			}
+		case xml.CharData:
+			if inTitle {
+				title.Write(t)
+			}
+		}
+	}
+	return ""
+}
+
+// pageCount reads the page count word keeps in docProps/app.xml
+func (d *ooxmlDocument) pageCount() int {
+	decoder, done, err := d.decoder("docProps/app.xml")
+	if err != nil || decoder == nil {
+		return 0
+	}
+	defer done()
+
+	inPages := false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return 0
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			inPages = t.Name.Local == "Pages"
+		case xml.CharData:
+			if inPages {
+				pages, _ := strconv.Atoi(strings.TrimSpace(string(t)))
+				return pages
+			}
+		}
+	}
+}
+
+// paragraphs returns the text of every <w:p>/<a:p> of a part with the style of the paragraph
+func (d *ooxmlDocument) paragraphs(name string) ([]ooxmlParagraph, error) {
+	decoder, done, err := d.decoder(name)
+	if err != nil || decoder == nil {
+		return nil, err
+	}
+	defer done()
+
+	paragraphs := make([]ooxmlParagraph, 0)
+	var current *ooxmlParagraph
+	var text strings.Builder
+	inText := false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				current = &ooxmlParagraph{}
+				text.Reset()
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteString(" ")
+			case "br", "cr":
+				text.WriteString("\n")
+			case "pStyle":
+				if current != nil {
+					current.style = xmlAttr(t, "val")
+				}
+			case "numPr", "buChar", "buAutoNum":
+				if current != nil {
+					current.listItem = true
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if current != nil {
+					current.text = text.String()
+					paragraphs = append(paragraphs, *current)
+					current = nil
+				}
+			}
+		case xml.CharData:
+			if inText && current != nil {
+				text.Write(t)
+			}
+		}
+	}
+
+	return paragraphs, nil
+}
+
+type ooxmlParagraph struct {
+	text     string
+	style    string
+	listItem bool
+}
+
+// addParagraphs turns paragraphs into blocks, consecutive list items are grouped in one list block
+func addParagraphs(pageData *models.PageData, paragraphs []ooxmlParagraph) {
+	items := make([]string, 0)
+	flushList := func() {
+		if len(items) > 0 {
+			pageData.Blocks = append(pageData.Blocks, models.ContentBlock{Type: models.BlockList, Text: strings.Join(items, "\n")})
+			items = items[:0]
+		}
+	}
+
+	for _, paragraph := range paragraphs {
+		text := normalizeSpace(paragraph.text)
+		if text == "" {
+			continue
+		}
+
+		if paragraph.listItem {
+			items = append(items, text)
+			continue
+		}
+		flushList()
+
+		if match := headingStyle.FindStringSubmatch(paragraph.style); match != nil {
+			level := int(match[1][0] - '0')
+			pageData.Blocks = append(pageData.Blocks, models.ContentBlock{Type: models.BlockHeading, Level: level, Text: text})
+			pageData.Headings["h"+match[1]] = append(pageData.Headings["h"+match[1]], text)
+			continue
+		}
+		if strings.EqualFold(paragraph.style, "Title") {
+			pageData.Blocks = append(pageData.Blocks, models.ContentBlock{Type: models.BlockHeading, Level: 1, Text: text})
+			continue
+		}
+		pageData.Blocks = append(pageData.Blocks, models.ContentBlock{Type: models.BlockParagraph, Text: text})
+	}
+	flushList()
+}
+
+func (c *Crawler) extractDOCX(body []byte, pageData *models.PageData) error {
+	document, err := openOOXML(body)
+	if err != nil {
+		return err
+	}
+
+	paragraphs, err := document.paragraphs("word/document.xml")
+	if err != nil {
+		return err
+	}
+	if paragraphs == nil {
+		return fmt.Errorf("not a word document, word/document.xml is missing")
+	}
+
+	pageData.Title = document.title()
+	pageData.PageCount = document.pageCount()
+	addParagraphs(pageData, paragraphs)
+	return nil
+}
+
+// extractPPTX reads the slides in order, each slide starts with a heading holding its number
+func (c *Crawler) extractPPTX(body []byte, pageData *models.PageData) error {
+	document, err := openOOXML(body)
+	if err != nil {
+		return err
+	}
+
+	slides := document.numberedParts(slidePart)
+	if len(slides) == 0 {
+		return fmt.Errorf("not a presentation, no slides found")
+	}
+
+	pageData.Title = document.title()
+	pageData.PageCount = len(slides)
+
+	for i, slide := range slides {
+		paragraphs, err := document.paragraphs(slide)
+		if err != nil {
+			return err
+		}
+		pageData.Blocks = append(pageData.Blocks, models.ContentBlock{Type: models.BlockHeading, Level: 2, Text: fmt.Sprintf("Slide %d", i+1)})
+		addParagraphs(pageData, paragraphs)
+	}
+	return nil
+}
+
+// extractXLSX keeps every sheet as a table block, rows are rendered like HTML tables ("a | b")
+func (c *Crawler) extractXLSX(body []byte, pageData *models.PageData) error {
+	document, err := openOOXML(body)
+	if err != nil {
+		return err
+	}
+
+	sheets := document.numberedParts(worksheetPart)
+	if len(sheets) == 0 {
+		return fmt.Errorf("not a spreadsheet, no worksheets found")
+	}
+
+	sharedStrings, err := document.sharedStrings()
+	if err != nil {
+		return err
+	}
+
+	pageData.Title = document.title()
+	pageData.PageCount = len(sheets)
+
+	for _, sheet := range sheets {
+		rows, err := document.sheetRows(sheet, sharedStrings)
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			pageData.Blocks = append(pageData.Blocks, models.ContentBlock{Type: models.BlockTable, Text: strings.Join(rows, "\n")})
+		}
+	}
+	return nil
+}
+
+// sharedStrings reads the string table cells of type "s" point to
+func (d *ooxmlDocument) sharedStrings() ([]string, error) {
+	decoder, done, err := d.decoder("xl/sharedStrings.xml")
+	if err != nil || decoder == nil {
+		return nil, err
+	}
+	defer done()
+
+	strs := make([]string, 0)
+	var current strings.Builder
+	inText := false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read shared strings: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				current.Reset()
+			case "t":
+				inText = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				strs = append(strs, normalizeSpace(current.String()))
+			case "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				current.Write(t)
+			}
+		}
+	}
+
+	return strs, nil
+}
+
+// sheetRows renders the non empty rows of a worksheet
+func (d *ooxmlDocument) sheetRows(name string, sharedStrings []string) ([]string, error) {
+	decoder, done, err := d.decoder(name)
+	if err != nil || decoder == nil {
+		return nil, err
+	}
+	defer done()
+
+	rows := make([]string, 0)
+	cells := make([]string, 0)
+	cellType := ""
+	var value strings.Builder
+	inValue := false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				cells = cells[:0]
+			case "c":
+				cellType = xmlAttr(t, "t")
+				value.Reset()
+			case "v", "t":
+				inValue = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				text := normalizeSpace(value.String())
+				if cellType == "s" {
+					if index, err := strconv.Atoi(text); err == nil && index >= 0 && index < len(sharedStrings) {
+						text = sharedStrings[index]
+					}
+				}
+				if text != "" {
+					cells = append(cells, text)
+				}
+			case "row":
+				if len(cells) > 0 {
+					rows = append(rows, strings.Join(cells, " | "))
+				}
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
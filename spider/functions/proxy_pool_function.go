@@ -0,0 +1,360 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/froxy/config"
+	"golang.org/x/net/proxy"
+)
+
+// hosts remembered by the pool, for sticky proxies and per proxy for the hosts refusing it.
+// Past that the map starts over.
+const maxProxyHosts = 10000
+
+// ProxyPool sends the requests through the configured proxies. It is the transport of the fetcher,
+// each proxy keeps its own transport so keep-alive connections survive between pages.
+// Without proxies every request goes out directly.
+type ProxyPool struct {
+	mu      sync.Mutex
+	proxies []*poolProxy
+	direct  *http.Transport
+	next    int
+	// host -> proxy when the pool is sticky
+	byHost map[string]*poolProxy
+	config config.ProxyPoolConfig
+}
+
+type poolProxy struct {
+	name      string // the url without its credentials, for the logs
+	transport *http.Transport
+	// failures to reach the proxy itself
+	failures int
+	ejected  time.Time // zero while the proxy is in use
+	// 403 and 429 answers, they only tell the proxy is blocked by that host
+	hosts map[string]hostRefusals
+}
+
+type hostRefusals struct {
+	count   int
+	blocked time.Time // zero while the host still gets requests through the proxy
+}
+
+// proxyDialError is a failure to connect to the proxy, not to the site behind it
+type proxyDialError struct {
+	err error
+}
+
+func (e *proxyDialError) Error() string {
+	return e.err.Error()
+}
+
+func (e *proxyDialError) Unwrap() error {
+	return e.err
+}
+
+// isProxyFailure tells the errors of the proxy itself apart from the ones of the site:
+// connecting to the proxy and the TLS handshake with an https proxy
+func isProxyFailure(err error) bool {
+	var dialErr *proxyDialError
+	if errors.As(err, &dialErr) {
+		return true
+	}
+	// net/http reports the dial and handshake failures of an http(s) proxy so
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "proxyconnect"
+}
+
+// proxyNoteKey is the context key of withProxyNote
+type proxyNoteKey struct{}
+
+// withProxyNote returns a context in which RoundTrip writes the name of the proxy it went through
+func withProxyNote(ctx context.Context) (context.Context, *string) {
+	note := new(string)
+	return context.WithValue(ctx, proxyNoteKey{}, note), note
+}
+
+// proxyPool is shared by every crawler of the process, Configure replaces it
+var proxyPool = &ProxyPool{
+	direct: newTransport(),
+	byHost: make(map[string]*poolProxy),
+	config: config.Default().ProxyPool,
+}
+
+// NewProxyPool builds the transports of the proxies, see config.Config.Proxies for the accepted forms
+func NewProxyPool(proxies []string, poolConfig config.ProxyPoolConfig) (*ProxyPool, error) {
+	pool := &ProxyPool{
+		direct: newTransport(),
+		byHost: make(map[string]*poolProxy),
+		config: poolConfig,
+	}
+
+	for _, raw := range proxies {
+		proxyURL, err := parseProxyURL(raw)
+		if err != nil {
+			return nil, err
+		}
+		transport, err := proxyTransport(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		pool.proxies = append(pool.proxies, &poolProxy{name: proxyURL.Redacted(), transport: transport})
+	}
+	return pool, nil
+}
+
+// parseProxyURL accepts socks5://, socks5h://, http:// and https:// urls, a bare host:port is a SOCKS5 proxy
+func parseProxyURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "socks5://" + raw
+	}
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", raw, err)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", raw)
+	}
+	return proxyURL, nil
+}
+
+func proxyTransport(proxyURL *url.URL) (*http.Transport, error) {
+	transport := newTransport()
+
+	switch proxyURL.Scheme {
+	case "http", "https":
+		// credentials of the url are sent as Proxy-Authorization, https targets go through CONNECT
+		transport.Proxy = http.ProxyURL(proxyURL)
+
+	case "socks5", "socks5h":
+		// the proxy itself is reached with the dial timeout and the dns cache
+		dialer, err := proxy.FromURL(proxyURL, proxyDialer(routeDialer()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create SOCKS5 dialer for %s: %w", proxyURL.Redacted(), err)
+		}
+		contextDialer, ok := dialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, fmt.Errorf("SOCKS5 dialer for %s does not support contexts", proxyURL.Redacted())
+		}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return contextDialer.DialContext(ctx, network, addr)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+	return transport, nil
+}
+
+// proxyDialer marks the failures to reach a SOCKS proxy, the ones of the site come back from the proxy
+func proxyDialer(dial contextDialer) contextDialer {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, &proxyDialError{err: err}
+		}
+		return conn, nil
+	}
+}
+
+// RoundTrip sends the request through the proxy picked for its host and records how the proxy did
+func (p *ProxyPool) RoundTrip(request *http.Request) (*http.Response, error) {
+	if len(p.proxies) == 0 {
+		return p.direct.RoundTrip(request)
+	}
+
+	host := request.URL.Host
+	picked := p.pick(host)
+	if note, ok := request.Context().Value(proxyNoteKey{}).(*string); ok {
+		*note = picked.name
+	}
+	resp, err := picked.transport.RoundTrip(request)
+
+	switch {
+	case err != nil:
+		// errors of the site (timeouts, resets, a bad certificate...) are not the proxy's fault,
+		// neither is the crawl being stopped
+		if request.Context().Err() == nil && isProxyFailure(err) {
+			p.failure(picked, err.Error())
+		}
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests:
+		p.refused(picked, host, resp.StatusCode)
+	default:
+		p.success(picked, host)
+	}
+	return resp, err
+}
+
+// pick returns the proxy for the host: its sticky proxy if it still works, otherwise the next one in turn
+func (p *ProxyPool) pick(host string) *poolProxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.config.Sticky {
+		if picked, ok := p.byHost[host]; ok && p.usable(picked, host) {
+			return picked
+		}
+	}
+
+	var picked *poolProxy
+	for range p.proxies {
+		candidate := p.proxies[p.next]
+		p.next = (p.next + 1) % len(p.proxies)
+		if p.usable(candidate, host) {
+			picked = candidate
+			break
+		}
+	}
+
+	// every proxy is ejected or blocked by the host, the one ejected first is the most likely to work again
+	if picked == nil {
+		picked = p.proxies[0]
+		for _, candidate := range p.proxies[1:] {
+			if candidate.ejected.Before(picked.ejected) {
+				picked = candidate
+			}
+		}
+	}
+
+	if p.config.Sticky {
+		if _, ok := p.byHost[host]; !ok && len(p.byHost) >= maxProxyHosts {
+			p.byHost = make(map[string]*poolProxy)
+		}
+		p.byHost[host] = picked
+	}
+	return picked
+}
+
+// usable reports whether the proxy is in use and not blocked by the host, the caller must hold p.mu
+func (p *ProxyPool) usable(candidate *poolProxy, host string) bool {
+	if !candidate.ejected.IsZero() {
+		return false
+	}
+	refusals := candidate.hosts[host]
+	return refusals.blocked.IsZero() || time.Since(refusals.blocked) >= p.config.Cooldown
+}
+
+func (p *ProxyPool) success(picked *poolProxy, host string) {
+	p.mu.Lock()
+	picked.failures = 0
+	delete(picked.hosts, host)
+	p.mu.Unlock()
+}
+
+// refused counts a 403 or 429 answer against the proxy for that host only,
+// the host is sent through other proxies for a while once they pile up
+func (p *ProxyPool) refused(picked *poolProxy, host string, statusCode int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	refusals, known := picked.hosts[host]
+	if picked.hosts == nil || !known && len(picked.hosts) >= maxProxyHosts {
+		picked.hosts = make(map[string]hostRefusals)
+	}
+	refusals.count++
+	blocked := refusals.count >= p.config.MaxFailures &&
+		(refusals.blocked.IsZero() || time.Since(refusals.blocked) >= p.config.Cooldown)
+	if blocked {
+		refusals.blocked = time.Now()
+	}
+	picked.hosts[host] = refusals
+
+	if blocked {
+		log.Printf("Proxy %s blocked by %s after %d refusals (last: status %d)", picked.name, host, refusals.count, statusCode)
+		appendLog(fmt.Sprintf("Proxy %s blocked by %s after %d refusals (last: status %d)", picked.name, host, refusals.count, statusCode))
+	}
+}
+
+func (p *ProxyPool) failure(picked *poolProxy, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	picked.failures++
+	if picked.failures < p.config.MaxFailures || !picked.ejected.IsZero() {
+		return
+	}
+
+	picked.ejected = time.Now()
+	// idle connections through a blocked proxy are useless
+	picked.transport.CloseIdleConnections()
+	log.Printf("Proxy %s ejected after %d failures (last: %s)", picked.name, picked.failures, reason)
+	appendLog(fmt.Sprintf("Proxy %s ejected after %d failures (last: %s)", picked.name, picked.failures, reason))
+}
+
+// MonitorHealth probes the proxies until the context is done. A proxy in use that fails the probe
+// counts a failure, an ejected one is put back once its cooldown is over and the probe answers.
+func (p *ProxyPool) MonitorHealth(ctx context.Context) {
+	if len(p.proxies) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, candidate := range p.proxies {
+			p.mu.Lock()
+			ejected := !candidate.ejected.IsZero()
+			coolingDown := ejected && time.Since(candidate.ejected) < p.config.Cooldown
+			p.mu.Unlock()
+			if coolingDown {
+				continue
+			}
+
+			err := p.probe(ctx, candidate)
+			if ctx.Err() != nil {
+				return
+			}
+
+			switch {
+			case err != nil && ejected:
+				log.Printf("Proxy %s still failing: %v", candidate.name, err)
+			case err != nil:
+				p.failure(candidate, "health check: "+err.Error())
+			case ejected:
+				p.mu.Lock()
+				candidate.ejected = time.Time{}
+				candidate.failures = 0
+				p.mu.Unlock()
+				log.Printf("Proxy %s is healthy again", candidate.name)
+				appendLog(fmt.Sprintf("Proxy %s is healthy again", candidate.name))
+			}
+		}
+	}
+}
+
+func (p *ProxyPool) probe(ctx context.Context, candidate *poolProxy) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, "GET", p.config.HealthCheckURL, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("User-Agent", userAgent)
+
+	resp, err := candidate.transport.RoundTrip(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package functions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/froxy/db"
+	"github.com/froxy/models"
+)
+
+const (
+	defaultRecrawlInterval = 24 * time.Hour
+	minRecrawlInterval     = time.Hour
+	maxRecrawlInterval     = 30 * 24 * time.Hour
+	// how often the scheduler looks for pages that are due
+	recrawlCheckInterval = 5 * time.Minute
+	// how many due pages are queued per check
+	recrawlBatchSize = 500
+	// idle workers in recrawl mode poll the queue at this pace
+	recrawlIdleWait = 30 * time.Second
+)
+
+// nextRecrawlInterval adapts the interval to how often the page really changes:
+// it is halved when the page changed since the last visit and grows by half when it did not
+func nextRecrawlInterval(current time.Duration, changed bool) time.Duration {
+	if current <= 0 {
+		return defaultRecrawlInterval
+	}
+
+	if changed {
+		current /= 2
+	} else {
+		current += current / 2
+	}
+
+	if current < minRecrawlInterval {
+		return minRecrawlInterval
+	}
+	if current > maxRecrawlInterval {
+		return maxRecrawlInterval
+	}
+	return current
+}
+
+// initialRecrawlInterval uses the sitemap changefreq hint for pages we never stored before
+func initialRecrawlInterval(link models.Link) time.Duration {
+	switch link.ChangeFreq {
+	case "always", "hourly":
+		return minRecrawlInterval
+	case "daily":
+		return 24 * time.Hour
+	case "weekly":
+		return 7 * 24 * time.Hour
+	case "monthly", "yearly", "never":
+		return maxRecrawlInterval
+	}
+	return defaultRecrawlInterval
+}
+
+func contentHash(pageData *models.PageData) string {
+	hash := sha256.Sum256([]byte(pageData.Title + "\n" + pageData.MainContent))
+	return hex.EncodeToString(hash[:])
+}
+
+// markUnchanged records a recrawl that did not change the page, so it is not embedded again
+func (c *Crawler) markUnchanged(freshness models.PageFreshness, resp *http.Response) error {
+	freshness.RecrawlInterval = nextRecrawlInterval(freshness.RecrawlInterval, false)
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		freshness.ETag = etag
+	}
+	if lastMod, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		freshness.LastModified = lastMod
+	}
+
+	if err := db.GetPostgresHandler().MarkPageUnchanged(freshness); err != nil {
+		return fmt.Errorf("failed to record unchanged page: %w", err)
+	}
+
+	log.Printf("%s unchanged (status %d), next visit in %v", freshness.URL, resp.StatusCode, freshness.RecrawlInterval)
+	appendLog(fmt.Sprintf("%s unchanged (status %d), next visit in %v", freshness.URL, resp.StatusCode, freshness.RecrawlInterval))
+	return nil
+}
+
+// postponeRecrawl pushes back a stored page whose visit ended without storing it again,
+// otherwise the scheduler would queue it on every check
+func (c *Crawler) postponeRecrawl(freshness models.PageFreshness) {
+	interval := nextRecrawlInterval(freshness.RecrawlInterval, false)
+	if err := db.GetPostgresHandler().MarkPageChecked(freshness.URL, interval); err != nil {
+		log.Printf("Failed to postpone the recrawl of %s: %v", freshness.URL, err)
+		return
+	}
+	log.Printf("%s not stored this time, next visit in %v", freshness.URL, interval)
+}
+
+// dropGonePage deletes a stored page the site answered 404 or 410 for
+func (c *Crawler) dropGonePage(url string, statusCode int) {
+	if err := db.GetPostgresHandler().DeletePage(url); err != nil {
+		log.Printf("Failed to delete %s: %v", url, err)
+		return
+	}
+	log.Printf("%s is gone (status %d), deleted it", url, statusCode)
+	appendLog(fmt.Sprintf("%s is gone (status %d), deleted it", url, statusCode))
+}
+
+// Recrawl revisits the stored pages as they become due and keeps running until shutdown
+func (c *Crawler) Recrawl(workerCount int) {
+	c.restoreTraps()
+	c.restoreFrontier()
+	c.enqueueDuePages()
+	// a plain crawl leaves the stored pages alone, only this mode revisits them
+	go c.runRecrawlScheduler()
+	c.run(workerCount, 0)
+}
+
+func (c *Crawler) runRecrawlScheduler() {
+	ticker := time.NewTicker(recrawlCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Ctx.Done():
+			return
+		case <-ticker.C:
+			c.enqueueDuePages()
+		}
+	}
+}
+
+func (c *Crawler) enqueueDuePages() {
+	links, err := db.GetPostgresHandler().DuePages(recrawlBatchSize)
+	if err != nil {
+		log.Printf("Failed to get pages due for recrawl: %v", err)
+		return
+	}
+
+	for _, link := range links {
+		c.enqueueRecrawl(link)
+	}
+
+	if len(links) > 0 {
+		log.Printf("Queued %d pages due for recrawl", len(links))
+		appendLog(fmt.Sprintf("Queued %d pages due for recrawl", len(links)))
+	}
+	// fetches that failed earlier get their next pass along with the due pages
+	failed, err := db.GetPostgresHandler().DueFailedURLs(recrawlBatchSize)
+	if err != nil {
+		log.Printf("Failed to get failed urls due for a retry: %v", err)
+		return
+	}
+
+	for _, link := range failed {
+		c.enqueueRecrawl(link)
+	}
+
+	if len(failed) > 0 {
+		log.Printf("Queued %d failed urls for a retry", len(failed))
+		appendLog(fmt.Sprintf("Queued %d failed urls for a retry", len(failed)))
+	}
+}
+
+// enqueueRecrawl queues a page even though it was already visited, through the same checks as a new link
+func (c *Crawler) enqueueRecrawl(link models.Link) {
+	c.Mu.Lock()
+	if c.frontier.Contains(link.URL) {
+		c.Mu.Unlock()
+		return
+	}
+	visited := c.VisitedUrls.Contains(link.URL)
+	if err := c.VisitedUrls.Remove(link.URL); err != nil {
+		log.Printf("Failed to forget %s: %v", link.URL, err)
+		c.Mu.Unlock()
+		return
+	}
+	admitted, spill := c.queueLocked(link, visited)
+	if !admitted && visited {
+		// a trap or the scope turned it down, it stays done
+		if err := c.VisitedUrls.Add(link.URL); err != nil {
+			log.Printf("Failed to mark %s as seen: %v", link.URL, err)
+		}
+	}
+	c.Mu.Unlock()
+
+	if !admitted {
+		return
+	}
+
+	status := db.FrontierQueued
+	if spill {
+		status = db.FrontierSpilled
+	}
+	if err := db.GetPostgresHandler().UpdateFrontierStatus(link.URL, status); err != nil {
+		log.Printf("Failed to persist %s to the frontier: %v", link.URL, err)
+	}
+}
@@ -0,0 +1,113 @@
+package functions
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/froxy/models"
+	"github.com/froxy/utils"
+)
+
+// Redirects, meta refresh and rel=canonical all say the same thing: this document lives at another url.
+// The page is stored once under that url and the others are recorded as its aliases.
+
+// a refresh slower than this is a timed notice ("you will be redirected..."), not a redirect
+const maxRefreshDelay = 5
+
+var (
+	refreshURLPattern = regexp.MustCompile(`(?i)^\s*([\d.]+)?\s*[;,]?\s*(?:url\s*=\s*)?['"]?([^'"]*)['"]?\s*$`)
+	linkHeaderPattern = regexp.MustCompile(`<([^>]+)>\s*;[^,]*rel="?canonical"?`)
+)
+
+// redirectChain returns the normalized urls requested before the final one, in the order they were visited
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for request := resp.Request; request != nil && request.Response != nil; {
+		request = request.Response.Request
+		if request == nil {
+			break
+		}
+		if normalized, err := utils.NormalizeURL(request.URL.String()); err == nil {
+			chain = append([]string{normalized}, chain...)
+		}
+	}
+	return chain
+}
+
+// refreshTarget returns the normalized target of an immediate meta refresh, or "" when there is none
+func refreshTarget(content, pageURL string) string {
+	match := refreshURLPattern.FindStringSubmatch(content)
+	if match == nil || strings.TrimSpace(match[2]) == "" {
+		return ""
+	}
+	if match[1] != "" {
+		if delay, err := strconv.ParseFloat(match[1], 64); err != nil || delay > maxRefreshDelay {
+			return ""
+		}
+	}
+	return resolveURL(pageURL, match[2])
+}
+
+// canonicalURL returns the normalized canonical url of the page, from <link rel=canonical> or the Link header.
+// Canonicals pointing to another site are ignored, a page cannot claim to be someone else's.
+func canonicalURL(pageData *models.PageData, resp *http.Response, pageURL string) string {
+	href := pageData.Canonical
+	if href == "" {
+		for _, header := range resp.Header.Values("Link") {
+			if match := linkHeaderPattern.FindStringSubmatch(header); match != nil {
+				href = match[1]
+				break
+			}
+		}
+	}
+	if href == "" {
+		return ""
+	}
+
+	canonical := resolveURL(pageURL, href)
+	if canonical == "" || !sameSite(canonical, pageURL) {
+		return ""
+	}
+	return canonical
+}
+
+// resolveURL resolves a reference against the page url and normalizes it
+func resolveURL(pageURL, ref string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	target, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ""
+	}
+	normalized, err := utils.NormalizeURL(target.String())
+	if err != nil {
+		return ""
+	}
+	return normalized
+}
+
+func sameSite(a, b string) bool {
+	return strings.TrimPrefix(hostOf(a), "www.") == strings.TrimPrefix(hostOf(b), "www.")
+}
+
+// pageAliases lists the urls that led to the stored url, each with the reason it is an alias
+func pageAliases(storedURL, finalURL string, chain []string) []models.URLAlias {
+	aliases := make([]models.URLAlias, 0, len(chain)+1)
+	seen := map[string]bool{storedURL: true}
+
+	for _, redirected := range chain {
+		if !seen[redirected] {
+			seen[redirected] = true
+			aliases = append(aliases, models.URLAlias{URL: redirected, Reason: models.AliasRedirect})
+		}
+	}
+	if !seen[finalURL] {
+		aliases = append(aliases, models.URLAlias{URL: finalURL, Reason: models.AliasCanonical})
+	}
+	return aliases
+}
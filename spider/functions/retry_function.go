@@ -0,0 +1,288 @@
+package functions
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/froxy/db"
+	"github.com/froxy/models"
+)
+
+const (
+	// tries of a page within the same visit
+	maxFetchAttempts = 3
+	retryBaseDelay   = time.Second
+	// a longer wait is not spent holding a worker, the url is tried again in a later pass
+	maxInlineRetryWait = 30 * time.Second
+	// Retry-After values past this are not taken seriously
+	maxRetryAfter = 24 * time.Hour
+)
+
+// later passes over the failed urls, they are picked up by the recrawl scheduler
+var failedRetryPolicy = models.RetryPolicy{
+	BaseDelay:   10 * time.Minute,
+	MaxDelay:    24 * time.Hour,
+	MaxAttempts: 5,
+}
+
+// FetchError is a fetch that failed, classified by models.Outcome*
+type FetchError struct {
+	Outcome    string
+	StatusCode int
+	// what the server asked for through Retry-After, zero when it did not
+	RetryAfter time.Duration
+	Err        error
+	temporary  bool
+}
+
+func (e *FetchError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s: status %d", e.Outcome, e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %v", e.Outcome, e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the same fetch may work later
+func (e *FetchError) Temporary() bool {
+	return e.temporary
+}
+
+// classifyError tells why a request got no response at all
+func classifyError(err error) *FetchError {
+	fetchErr := &FetchError{Outcome: models.OutcomeConnection, Err: err, temporary: true}
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	switch {
+	case errors.As(err, &dnsErr):
+		fetchErr.Outcome = models.OutcomeDNS
+		// a name that does not exist will not exist in ten minutes either
+		fetchErr.temporary = !dnsErr.IsNotFound
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		fetchErr.Outcome = models.OutcomeTimeout
+	case errors.As(err, &certErr):
+		fetchErr.temporary = false
+	}
+	return fetchErr
+}
+
+// classifyStatus turns an answer we cannot use into an error, nil for 200 (and 304 when we asked for it)
+func classifyStatus(resp *http.Response, conditional bool) *FetchError {
+	status := resp.StatusCode
+	if status == http.StatusOK || (status == http.StatusNotModified && conditional) {
+		return nil
+	}
+
+	fetchErr := &FetchError{StatusCode: status, Err: fmt.Errorf("status %d", status)}
+	switch {
+	case status == http.StatusTooManyRequests:
+		fetchErr.Outcome = models.OutcomeRateLimited
+		fetchErr.temporary = true
+	case status >= 500:
+		fetchErr.Outcome = models.OutcomeServerError
+		fetchErr.temporary = status != http.StatusNotImplemented
+	case status >= 400:
+		fetchErr.Outcome = models.OutcomeClientError
+		fetchErr.temporary = status == http.StatusRequestTimeout
+	default:
+		fetchErr.Outcome = models.OutcomeUnexpectedStatus
+	}
+
+	if fetchErr.temporary {
+		fetchErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return fetchErr
+}
+
+// parseRetryAfter reads a Retry-After header, either a number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = date.Sub(now)
+	}
+
+	if delay < 0 {
+		return 0
+	}
+	return min(delay, maxRetryAfter)
+}
+
+// retryDelay is the wait before the next try: exponential with full jitter, never below Retry-After
+func retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	ceiling := retryBaseDelay << attempt
+	delay := time.Duration(rand.Int64N(int64(ceiling))) + retryBaseDelay/2
+	return max(delay, retryAfter)
+}
+
+// fetchPage gets the page, trying again while the failures look temporary.
+// It returns a response only for a 200, or a 304 to a conditional request.
+func (c *Crawler) fetchPage(pageURL string, freshness *models.PageFreshness) (*http.Response, FetchTiming, error) {
+	host := hostOf(pageURL)
+
+	for attempt := 1; ; attempt++ {
+		resp, timing, err := c.fetchOnce(pageURL, freshness)
+		if err == nil {
+			c.scheduler.Success(host)
+			return resp, timing, nil
+		}
+
+		var fetchErr *FetchError
+		if !errors.As(err, &fetchErr) || !fetchErr.Temporary() {
+			return nil, timing, err
+		}
+
+		wait := max(retryDelay(attempt, fetchErr.RetryAfter), c.backoffHost(host, fetchErr))
+		if attempt >= maxFetchAttempts || wait > maxInlineRetryWait {
+			return nil, timing, err
+		}
+
+		log.Printf("Fetching %s failed (%v), attempt %d/%d, retrying in %v", pageURL, err, attempt, maxFetchAttempts, wait)
+		appendLog(fmt.Sprintf("Fetching %s failed (%v), attempt %d/%d, retrying in %v", pageURL, err, attempt, maxFetchAttempts, wait))
+
+		select {
+		case <-c.Ctx.Done():
+			return nil, timing, c.Ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// fetchOnce sends one request, a response that is not usable is closed and returned as a *FetchError
+func (c *Crawler) fetchOnce(pageURL string, freshness *models.PageFreshness) (*http.Response, FetchTiming, error) {
+	// the fetcher timeouts bound each try, the crawler context stops them on shutdown
+	ctx, proxyUsed := withProxyNote(c.Ctx)
+	request, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, FetchTiming{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	request.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,application/pdf;q=0.8,*/*;q=0.7")
+	request.Header.Set("User-Agent", userAgent)
+	// request.Header.Set("Accept-Language", "en-US,en;q=0.5")
+	if freshness != nil {
+		if freshness.ETag != "" {
+			request.Header.Set("If-None-Match", freshness.ETag)
+		}
+		if !freshness.LastModified.IsZero() {
+			request.Header.Set("If-Modified-Since", freshness.LastModified.UTC().Format(http.TimeFormat))
+		}
+	}
+
+	startTime := time.Now()
+	resp, timing, err := c.fetcher.Do(request)
+
+	attempt := models.FetchAttempt{
+		URL:             pageURL,
+		Host:            hostOf(pageURL),
+		Outcome:         models.OutcomeOK,
+		ResponseTime:    timing.ResponseTime,
+		TimeToFirstByte: timing.TimeToFirstByte,
+		Proxy:           *proxyUsed,
+		AttemptedAt:     startTime,
+	}
+
+	if err != nil {
+		// shutting down is not a failure of the page
+		if c.Ctx.Err() != nil {
+			return nil, timing, c.Ctx.Err()
+		}
+		fetchErr := classifyError(err)
+		attempt.Outcome = fetchErr.Outcome
+		attempt.Error = err.Error()
+		c.attempts.add(attempt)
+		return nil, timing, fetchErr
+	}
+
+	attempt.StatusCode = resp.StatusCode
+	fetchErr := classifyStatus(resp, freshness != nil)
+	if fetchErr != nil {
+		attempt.Outcome = fetchErr.Outcome
+		attempt.Error = fetchErr.Error()
+	}
+	c.attempts.add(attempt)
+
+	if fetchErr != nil {
+		resp.Body.Close()
+		return nil, timing, fetchErr
+	}
+	return resp, timing, nil
+}
+
+// backoffHost slows the whole host down when it is rate limiting us or struggling,
+// it returns how long the host is left alone
+func (c *Crawler) backoffHost(host string, fetchErr *FetchError) time.Duration {
+	switch {
+	case fetchErr.RetryAfter > 0:
+		c.scheduler.Backoff(host, fetchErr.RetryAfter)
+		return fetchErr.RetryAfter
+	case fetchErr.Outcome == models.OutcomeRateLimited, fetchErr.Outcome == models.OutcomeServerError,
+		fetchErr.Outcome == models.OutcomeTimeout, fetchErr.Outcome == models.OutcomeConnection:
+		return c.scheduler.Failure(host)
+	}
+	return 0
+}
+
+// recordFetchFailure stores why the page could not be fetched.
+// It reports whether the url is tried again in a later pass.
+func (c *Crawler) recordFetchFailure(link models.Link, err error) bool {
+	var fetchErr *FetchError
+	if !errors.As(err, &fetchErr) {
+		return false
+	}
+
+	failure := models.FailedURL{
+		Link:       link,
+		Outcome:    fetchErr.Outcome,
+		StatusCode: fetchErr.StatusCode,
+		Error:      err.Error(),
+		Retry:      fetchErr.Temporary(),
+		RetryAfter: fetchErr.RetryAfter,
+	}
+	nextRetry, dbErr := db.GetPostgresHandler().RecordFailedURL(failure, failedRetryPolicy)
+	if dbErr != nil {
+		log.Printf("Failed to record the failure of %s: %v", link.URL, dbErr)
+		return false
+	}
+
+	if nextRetry.IsZero() {
+		log.Printf("Giving up on %s: %v", link.URL, err)
+		appendLog(fmt.Sprintf("Giving up on %s: %v", link.URL, err))
+		return false
+	}
+
+	log.Printf("Fetching %s failed (%v), next try at %s", link.URL, err, nextRetry.Format(time.RFC3339))
+	appendLog(fmt.Sprintf("Fetching %s failed (%v), next try at %s", link.URL, err, nextRetry.Format(time.RFC3339)))
+	return true
+}
+
+// markFailed keeps a url away until its next pass, rediscovering it before that does not queue it
+func (c *Crawler) markFailed(url string) {
+	if err := c.VisitedUrls.Add(url); err != nil {
+		log.Printf("Failed to mark %s as seen: %v", url, err)
+	}
+
+	if err := db.GetPostgresHandler().UpdateFrontierStatus(url, db.FrontierFailed); err != nil {
+		log.Printf("Failed to mark %s as failed: %v", url, err)
+	}
+}
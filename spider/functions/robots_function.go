@@ -0,0 +1,189 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/temoto/robotstxt"
+)
+
+const (
+	// RFC 9309: crawlers should not use a cached robots.txt for more than 24 hours
+	robotsTTL = 24 * time.Hour
+	// when robots.txt is unreachable (5xx, timeouts) we assume complete disallow, but only for a while
+	robotsErrorTTL = 30 * time.Minute
+	// RFC 9309: a parsing limit of at least 500 KiB must be enforced
+	robotsMaxBytes = 500 * 1024
+	// RFC 9309: crawlers should follow at least five redirects, past that robots.txt is unavailable
+	robotsMaxRedirects = 5
+)
+
+type robotsEntry struct {
+	data        *robotstxt.RobotsData
+	disallowAll bool
+	expires     time.Time
+}
+
+func (e *robotsEntry) allowed(targetPath string) bool {
+	if e.disallowAll {
+		return false
+	}
+	if e.data == nil {
+		return true
+	}
+	return e.data.TestAgent(targetPath, userAgent)
+}
+
+func (e *robotsEntry) crawlDelay() time.Duration {
+	if e.disallowAll || e.data == nil {
+		return 0
+	}
+	return e.data.FindGroup(userAgent).CrawlDelay
+}
+
+func (e *robotsEntry) sitemaps() []string {
+	if e.data == nil {
+		return nil
+	}
+	return e.data.Sitemaps
+}
+
+// robotsFetch is a robots.txt download in progress, the workers asking for the same domain wait for it
+type robotsFetch struct {
+	done  chan struct{}
+	entry *robotsEntry
+}
+
+// robots.txt outcomes (allow and deny) keyed by protocol + host, and the downloads in progress
+var robotsCache = make(map[string]*robotsEntry)
+var robotsFetches = make(map[string]*robotsFetch)
+var robotsCacheMu sync.RWMutex
+
+// CheckingRobotsRules returns an error if our user agent is not allowed to fetch targetPath on domain
+func (c *Crawler) CheckingRobotsRules(domain string, targetPath string) error {
+	entry := c.robotsFor(domain)
+
+	if delay := entry.crawlDelay(); delay > 0 {
+		c.scheduler.SetCrawlDelay(hostOf(domain), delay)
+	}
+
+	if entry.disallowAll {
+		return fmt.Errorf("robots.txt unavailable for %s, not fetching %s until %s", domain, targetPath, entry.expires.Format(time.RFC3339))
+	}
+
+	if !entry.allowed(targetPath) {
+		return fmt.Errorf("not allowed to fetch %s (blocked by robots.txt)", targetPath)
+	}
+
+	return nil
+}
+
+// robotsSitemaps returns the Sitemap: lines of the domain robots.txt
+func (c *Crawler) robotsSitemaps(domain string) []string {
+	return c.robotsFor(domain).sitemaps()
+}
+
+func (c *Crawler) robotsFor(domain string) *robotsEntry {
+	robotsCacheMu.RLock()
+	entry, exists := robotsCache[domain]
+	robotsCacheMu.RUnlock()
+
+	if exists && time.Now().Before(entry.expires) {
+		return entry
+	}
+
+	// one download per domain, the other workers on the host wait for its outcome
+	robotsCacheMu.Lock()
+	if entry, exists := robotsCache[domain]; exists && time.Now().Before(entry.expires) {
+		robotsCacheMu.Unlock()
+		return entry
+	}
+	fetch, inFlight := robotsFetches[domain]
+	if !inFlight {
+		fetch = &robotsFetch{done: make(chan struct{})}
+		robotsFetches[domain] = fetch
+	}
+	robotsCacheMu.Unlock()
+
+	if inFlight {
+		<-fetch.done
+		return fetch.entry
+	}
+
+	fetch.entry = c.fetchRobots(domain)
+
+	robotsCacheMu.Lock()
+	robotsCache[domain] = fetch.entry
+	delete(robotsFetches, domain)
+	robotsCacheMu.Unlock()
+	close(fetch.done)
+
+	return fetch.entry
+}
+
+// fetchRobots downloads and evaluates robots.txt following RFC 9309:
+// 2xx is parsed, 4xx and too many redirects mean no restrictions, 5xx and network errors mean complete disallow
+func (c *Crawler) fetchRobots(domain string) *robotsEntry {
+	now := time.Now()
+	unreachable := &robotsEntry{disallowAll: true, expires: now.Add(robotsErrorTTL)}
+
+	ctx, cancel := context.WithTimeout(c.Ctx, 15*time.Second)
+	defer cancel()
+	ctx = withRedirectLimit(ctx, robotsMaxRedirects)
+
+	request, err := http.NewRequestWithContext(ctx, "GET", domain+"/robots.txt", nil)
+	if err != nil {
+		log.Printf("Failed to create robots.txt request for %s: %v", domain, err)
+		return unreachable
+	}
+	request.Header.Set("User-Agent", userAgent)
+	request.Header.Set("Accept", "text/plain,*/*;q=0.8")
+
+	resp, _, err := c.fetcher.Do(request)
+	if err != nil {
+		log.Printf("Failed to fetch robots.txt for %s: %v", domain, err)
+		appendLog(fmt.Sprintf("Failed to fetch robots.txt for %s: %v", domain, err))
+		return unreachable
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		log.Printf("robots.txt for %s returned %d, disallowing until %s", domain, resp.StatusCode, unreachable.expires.Format(time.RFC3339))
+		appendLog(fmt.Sprintf("robots.txt for %s returned %d, disallowing until %s", domain, resp.StatusCode, unreachable.expires.Format(time.RFC3339)))
+		return unreachable
+
+	case resp.StatusCode >= 400:
+		return &robotsEntry{expires: now.Add(robotsTTL)}
+
+	case resp.StatusCode >= 300:
+		// the redirects left over the limit, robots.txt is unavailable
+		log.Printf("robots.txt for %s redirects more than %d times, treating it as unavailable", domain, robotsMaxRedirects)
+		appendLog(fmt.Sprintf("robots.txt for %s redirects more than %d times, treating it as unavailable", domain, robotsMaxRedirects))
+		return &robotsEntry{expires: now.Add(robotsTTL)}
+
+	case resp.StatusCode < 200:
+		log.Printf("Unexpected robots.txt status %d for %s", resp.StatusCode, domain)
+		return unreachable
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, robotsMaxBytes))
+	if err != nil {
+		log.Printf("Failed to read robots.txt for %s: %v", domain, err)
+		return unreachable
+	}
+
+	data, err := robotstxt.FromBytes(body)
+	if err != nil {
+		// an unparseable file carries no rules we could honor
+		log.Printf("Failed to parse robots.txt for %s: %v", domain, err)
+		return &robotsEntry{expires: now.Add(robotsTTL)}
+	}
+
+	return &robotsEntry{data: data, expires: now.Add(robotsTTL)}
+}
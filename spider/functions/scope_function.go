@@ -0,0 +1,210 @@
+package functions
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/froxy/models"
+)
+
+// crawlScope is the compiled scope of one seed, its counters are guarded by the crawler mutex
+type crawlScope struct {
+	host      string
+	config    models.ScopeConfig
+	allow     []*regexp.Regexp
+	deny      []*regexp.Regexp
+	pages     int
+	hostPages map[string]int
+}
+
+func newCrawlScope(seedURL string, config models.ScopeConfig) (*crawlScope, error) {
+	parsed, err := url.Parse(seedURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse seed url: %w", err)
+	}
+
+	scope := &crawlScope{
+		host:      strings.TrimPrefix(parsed.Hostname(), "www."),
+		config:    config,
+		hostPages: make(map[string]int),
+	}
+	if scope.allow, err = compilePatterns(config.Allow); err != nil {
+		return nil, err
+	}
+	if scope.deny, err = compilePatterns(config.Deny); err != nil {
+		return nil, err
+	}
+	return scope, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scope pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// covers reports whether the host belongs to the seed site, www. is the same site
+func (s *crawlScope) covers(host string) bool {
+	host = strings.TrimPrefix(host, "www.")
+	if host == s.host {
+		return true
+	}
+	return s.config.IncludeSubdomains && strings.HasSuffix(host, "."+s.host)
+}
+
+// allows checks the depth and the url patterns of a link the scope covers
+func (s *crawlScope) allows(link models.Link) bool {
+	if s.config.MaxDepth > 0 && link.Depth > s.config.MaxDepth {
+		return false
+	}
+	for _, re := range s.deny {
+		if re.MatchString(link.URL) {
+			return false
+		}
+	}
+	if len(s.allow) == 0 {
+		return true
+	}
+	for _, re := range s.allow {
+		if re.MatchString(link.URL) {
+			return true
+		}
+	}
+	return false
+}
+
+// full reports whether the page limits leave no room for another page on the host
+func (s *crawlScope) full(host string) bool {
+	if s.config.MaxPages > 0 && s.pages >= s.config.MaxPages {
+		return true
+	}
+	return s.config.MaxPagesPerHost > 0 && s.hostPages[host] >= s.config.MaxPagesPerHost
+}
+
+func (s *crawlScope) count(host string) {
+	s.pages++
+	s.hostPages[host]++
+}
+
+func (s *crawlScope) uncount(host string) {
+	s.pages--
+	s.hostPages[host]--
+}
+
+// addScope registers the scope of a seed, it must be done before the workers start
+func (c *Crawler) addScope(seedURL string, config models.ScopeConfig) error {
+	scope, err := newCrawlScope(seedURL, config)
+	if err != nil {
+		return err
+	}
+
+	c.Mu.Lock()
+	c.scopes = append(c.scopes, scope)
+	c.Mu.Unlock()
+	return nil
+}
+
+// scopeForLocked returns the scope covering the url, nil if no seed covers it. The caller must hold c.Mu
+func (c *Crawler) scopeForLocked(rawURL string) *crawlScope {
+	host := hostOf(rawURL)
+	for _, scope := range c.scopes {
+		if scope.covers(host) {
+			return scope
+		}
+	}
+	return nil
+}
+
+// inScope tells whether a discovered link may be queued.
+// Without seeds (recrawl mode) only links to the host of the page they were found on are followed.
+func (c *Crawler) inScope(link models.Link, fromURL string) bool {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	if len(c.scopes) == 0 {
+		return hostOf(link.URL) == hostOf(fromURL)
+	}
+
+	scope := c.scopeForLocked(link.URL)
+	return scope != nil && scope.allows(link)
+}
+
+// admitLocked counts a new url against the page limits of its scope, false when a limit is reached.
+// The caller must hold c.Mu
+func (c *Crawler) admitLocked(rawURL string) bool {
+	scope := c.scopeForLocked(rawURL)
+	if scope == nil {
+		return true
+	}
+
+	host := hostOf(rawURL)
+	if scope.full(host) {
+		return false
+	}
+	scope.count(host)
+	return true
+}
+
+// unadmitLocked gives back the room taken by admitLocked for an url that was not new after all.
+// The caller must hold c.Mu
+func (c *Crawler) unadmitLocked(rawURL string) {
+	if scope := c.scopeForLocked(rawURL); scope != nil {
+		scope.uncount(hostOf(rawURL))
+	}
+}
+
+// enqueueOutboundLinks queues the links of a crawled page that are in scope, one level deeper than the page.
+// A focused crawl ranks them by relevance to the topic and drops those under the threshold.
+func (c *Crawler) enqueueOutboundLinks(from models.Link, pageData *models.PageData) {
+	links := make([]models.Link, 0, len(pageData.OutboundLinks))
+	for _, outbound := range pageData.OutboundLinks {
+		link := models.Link{Text: outbound.Text, URL: outbound.URL, Depth: from.Depth + 1, Growth: urlGrowth(from, outbound.URL)}
+		if !c.inScope(link, pageData.URL) || c.VisitedUrls.Contains(link.URL) {
+			continue
+		}
+		links = append(links, link)
+	}
+
+	pruned := 0
+	if c.focus != nil && len(links) > 0 {
+		pageRelevance, err := c.focus.scoreLinks(pageData, links)
+		if err != nil {
+			// queued without a relevance rather than lost
+			log.Printf("Failed to score the links of %s: %v", pageData.URL, err)
+		} else {
+			kept := links[:0]
+			for _, link := range links {
+				if link.Relevance < c.focus.config.Threshold {
+					pruned++
+					continue
+				}
+				kept = append(kept, link)
+			}
+			links = kept
+			log.Printf("%s has a relevance of %.2f, %d links under the threshold", pageData.URL, pageRelevance, pruned)
+		}
+	}
+
+	queued := 0
+	for _, link := range links {
+		if c.safeEnqueue(link) {
+			queued++
+		}
+	}
+
+	if pruned > 0 {
+		appendLog(fmt.Sprintf("Pruned %d off-topic links found on %s", pruned, pageData.URL))
+	}
+	if queued > 0 {
+		log.Printf("Queued %d of %d links found on %s", queued, len(pageData.OutboundLinks), pageData.URL)
+	}
+}
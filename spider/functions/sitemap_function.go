@@ -0,0 +1,259 @@
+package functions
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/froxy/models"
+	"github.com/froxy/utils"
+)
+
+// Sitemap structures for XML parsing
+type Sitemap struct {
+	XMLName xml.Name     `xml:"urlset"`
+	URLs    []SitemapURL `xml:"url"`
+}
+
+type SitemapIndex struct {
+	XMLName  xml.Name           `xml:"sitemapindex"`
+	Sitemaps []SitemapReference `xml:"sitemap"`
+}
+
+type SitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod"`
+	ChangeFreq string `xml:"changefreq"`
+	Priority   string `xml:"priority"`
+}
+
+type SitemapReference struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+const (
+	// upper bound of urls taken from the sitemaps of a single site
+	maxSitemapURLsPerSite = 50000
+	// how deep we follow sitemap index files
+	maxSitemapDepth = 3
+	// the sitemaps protocol limits a file to 50MB uncompressed
+	maxSitemapBytes = 50 * 1024 * 1024
+	// priority assumed by the sitemaps protocol when none is given
+	defaultSitemapPriority = 0.5
+)
+
+// sitemapIngest collects the urls of one site across all of its sitemap files
+type sitemapIngest struct {
+	fetched map[string]bool
+	seen    map[string]bool
+	links   []models.Link
+}
+
+func (s *sitemapIngest) full() bool {
+	return len(s.links) >= maxSitemapURLsPerSite
+}
+
+// crawlFromSitemap populates the queue from the site sitemaps.
+// Sitemaps declared in robots.txt are used when present, otherwise the usual locations are probed.
+// If nothing is found the base URL is queued instead.
+func (c *Crawler) crawlFromSitemap(baseURL string) error {
+	sitemapURLs := c.robotsSitemaps(baseURL)
+	if len(sitemapURLs) == 0 {
+		sitemapURLs = []string{
+			baseURL + "/sitemap.xml",
+			baseURL + "/sitemap_index.xml",
+			baseURL + "/sitemaps.xml",
+			baseURL + "/sitemap.xml.gz",
+		}
+	}
+
+	ingest := &sitemapIngest{
+		fetched: make(map[string]bool),
+		seen:    make(map[string]bool),
+		links:   make([]models.Link, 0),
+	}
+
+	for _, sitemapURL := range sitemapURLs {
+		if ingest.full() {
+			log.Printf("Reached the limit of %d sitemap urls for %s", maxSitemapURLsPerSite, baseURL)
+			break
+		}
+		if err := c.ingestSitemap(ingest, sitemapURL, 0); err != nil {
+			log.Printf("Failed to ingest sitemap %s: %v", sitemapURL, err)
+		}
+	}
+
+	if len(ingest.links) == 0 {
+		log.Printf("No valid sitemap found for %s, adding base URL to queue", baseURL)
+		c.safeEnqueue(models.Link{URL: baseURL})
+		return nil
+	}
+
+	// Important and recently changed pages first
+	sort.SliceStable(ingest.links, func(i, j int) bool {
+		if ingest.links[i].Priority != ingest.links[j].Priority {
+			return ingest.links[i].Priority > ingest.links[j].Priority
+		}
+		return ingest.links[i].LastMod.After(ingest.links[j].LastMod)
+	})
+
+	log.Printf("Found %d URLs in the sitemaps of %s", len(ingest.links), baseURL)
+	appendLog(fmt.Sprintf("Found %d URLs in the sitemaps of %s", len(ingest.links), baseURL))
+
+	// the pages a sitemap lists are one link away from the site root
+	for _, link := range ingest.links {
+		link.Depth = 1
+		if c.inScope(link, baseURL) {
+			c.safeEnqueue(link)
+		}
+	}
+	return nil
+}
+
+// ingestSitemap fetches one sitemap file, following index files into their child sitemaps
+func (c *Crawler) ingestSitemap(ingest *sitemapIngest, sitemapURL string, depth int) error {
+	if ingest.fetched[sitemapURL] || ingest.full() {
+		return nil
+	}
+	ingest.fetched[sitemapURL] = true
+
+	log.Printf("Trying to fetch sitemap from: %s", sitemapURL)
+	body, err := c.fetchSitemap(sitemapURL)
+	if err != nil {
+		return err
+	}
+
+	var sitemap Sitemap
+	if err := xml.Unmarshal(body, &sitemap); err == nil && len(sitemap.URLs) > 0 {
+		log.Printf("Found sitemap %s with %d URLs", sitemapURL, len(sitemap.URLs))
+		for _, entry := range sitemap.URLs {
+			ingest.add(sitemapLink(entry))
+		}
+		return nil
+	}
+
+	var sitemapIndex SitemapIndex
+	if err := xml.Unmarshal(body, &sitemapIndex); err == nil && len(sitemapIndex.Sitemaps) > 0 {
+		if depth >= maxSitemapDepth {
+			return fmt.Errorf("sitemap index nested deeper than %d levels", maxSitemapDepth)
+		}
+
+		log.Printf("Found sitemap index %s with %d sitemaps", sitemapURL, len(sitemapIndex.Sitemaps))
+		for _, sitemapRef := range sitemapIndex.Sitemaps {
+			loc := strings.TrimSpace(sitemapRef.Loc)
+			if loc == "" {
+				continue
+			}
+			if err := c.ingestSitemap(ingest, loc, depth+1); err != nil {
+				log.Printf("Failed to ingest nested sitemap %s: %v", loc, err)
+			}
+		}
+		return nil
+	}
+
+	// The protocol also allows plain text sitemaps with one url per line
+	if links := parseTextSitemap(body); len(links) > 0 {
+		log.Printf("Found text sitemap %s with %d URLs", sitemapURL, len(links))
+		for _, link := range links {
+			ingest.add(link)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unrecognized sitemap format")
+}
+
+func (s *sitemapIngest) add(link models.Link) {
+	normalized, err := utils.NormalizeURL(link.URL)
+	if err != nil {
+		return
+	}
+	link.URL = normalized
+
+	if s.seen[link.URL] || s.full() {
+		return
+	}
+	s.seen[link.URL] = true
+	s.links = append(s.links, link)
+}
+
+func (c *Crawler) fetchSitemap(sitemapURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(c.Ctx, time.Minute)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, "GET", sitemapURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("User-Agent", userAgent)
+	request.Header.Set("Accept", "application/xml,text/xml,application/x-gzip,text/plain;q=0.9,*/*;q=0.8")
+
+	resp, _, err := c.fetcher.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sitemap: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sitemap not found, status: %d", resp.StatusCode)
+	}
+
+	reader := bufio.NewReader(io.LimitReader(resp.Body, maxSitemapBytes))
+
+	// .xml.gz files are served as-is, so we look at the gzip magic number rather than the headers
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip sitemap: %w", err)
+		}
+		defer gzipReader.Close()
+		return io.ReadAll(io.LimitReader(gzipReader, maxSitemapBytes))
+	}
+
+	return io.ReadAll(reader)
+}
+
+func sitemapLink(entry SitemapURL) models.Link {
+	link := models.Link{
+		URL:        strings.TrimSpace(entry.Loc),
+		ChangeFreq: strings.ToLower(strings.TrimSpace(entry.ChangeFreq)),
+		Priority:   defaultSitemapPriority,
+	}
+
+	if priority, err := strconv.ParseFloat(strings.TrimSpace(entry.Priority), 64); err == nil && priority >= 0 && priority <= 1 {
+		link.Priority = priority
+	}
+	if lastMod, ok := utils.ParseDate(entry.LastMod); ok {
+		link.LastMod = lastMod
+	}
+
+	return link
+}
+
+func parseTextSitemap(body []byte) []models.Link {
+	links := make([]models.Link, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "http://") && !strings.HasPrefix(line, "https://") {
+			// not a text sitemap
+			return nil
+		}
+		links = append(links, models.Link{URL: line, Priority: defaultSitemapPriority})
+	}
+	return links
+}
@@ -0,0 +1,493 @@
+package functions
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/froxy/models"
+	"golang.org/x/net/html"
+)
+
+// Structured data: JSON-LD scripts and microdata are read into generic schema.org entities,
+// the most specific one becomes the main entity of the page and OpenGraph/Twitter cards fill the gaps.
+
+// lower rank wins when picking the main entity, unknown types sit in the middle
+var entityRank = map[string]int{
+	"Product": 1, "Recipe": 1, "Event": 1, "JobPosting": 1, "Course": 1, "Book": 1,
+	"SoftwareApplication": 1, "Movie": 1, "LocalBusiness": 1, "Restaurant": 1,
+	"FAQPage": 2, "QAPage": 2, "HowTo": 2,
+	"NewsArticle": 3, "Article": 3, "BlogPosting": 3, "TechArticle": 3, "ScholarlyArticle": 3, "Report": 3,
+	"VideoObject": 4, "Organization": 7, "Person": 7,
+	"WebPage": 8, "AboutPage": 8, "ContactPage": 8, "CollectionPage": 8, "ItemPage": 8,
+	"WebSite": 9,
+}
+
+// types that describe the page around the content rather than the content itself
+var supportingEntities = map[string]bool{
+	"BreadcrumbList": true, "ItemList": true, "SiteNavigationElement": true,
+	"WPHeader": true, "WPFooter": true, "WPSideBar": true, "ImageObject": true, "SearchAction": true,
+}
+
+var openGraphTypes = map[string]string{
+	"article": "Article", "product": "Product", "book": "Book", "profile": "Person",
+	"website": "WebSite", "video.movie": "Movie", "video.other": "VideoObject",
+	"video.episode": "VideoObject", "music.song": "MusicRecording",
+}
+
+var htmlTags = regexp.MustCompile(`<[^>]*>`)
+
+// extractStructuredData reads the JSON-LD, microdata and social meta tags of the document
+func (c *Crawler) extractStructuredData(doc *html.Node) models.StructuredData {
+	entities := make([]map[string]any, 0)
+	openGraph := make(map[string]string)
+	twitter := make(map[string]string)
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.Data == "script" && strings.EqualFold(strings.TrimSpace(c.getAttributeValue(n, "type")), "application/ld+json"):
+				if n.FirstChild != nil {
+					var value any
+					if err := json.Unmarshal([]byte(n.FirstChild.Data), &value); err == nil {
+						entities = collectEntities(value, entities)
+					}
+				}
+				return
+
+			case n.Data == "meta":
+				key := c.getAttributeValue(n, "property")
+				if key == "" {
+					key = c.getAttributeValue(n, "name")
+				}
+				content := strings.TrimSpace(c.getAttributeValue(n, "content"))
+				key = strings.ToLower(key)
+
+				if content != "" {
+					if strings.HasPrefix(key, "twitter:") {
+						if _, ok := twitter[key]; !ok {
+							twitter[key] = content
+						}
+					} else if isOpenGraphKey(key) {
+						if _, ok := openGraph[key]; !ok {
+							openGraph[key] = content
+						}
+					}
+				}
+
+			case c.hasAttribute(n, "itemscope") && !c.hasAttribute(n, "itemprop"):
+				// top level microdata item, nested ones are read by microdataItem
+				entities = append(entities, c.microdataItem(n))
+			}
+		}
+
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	data := structuredDataFromEntities(entities)
+	if len(openGraph) > 0 {
+		data.OpenGraph = openGraph
+	}
+	if len(twitter) > 0 {
+		data.Twitter = twitter
+	}
+	fillFromSocialTags(&data)
+
+	return data
+}
+
+func isOpenGraphKey(key string) bool {
+	for _, prefix := range []string{"og:", "article:", "product:", "book:", "profile:", "video:", "music:"} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectEntities flattens arrays and @graph containers into a list of typed entities
+func collectEntities(value any, entities []map[string]any) []map[string]any {
+	switch v := value.(type) {
+	case []any:
+		for _, item := range v {
+			entities = collectEntities(item, entities)
+		}
+	case map[string]any:
+		if graph, ok := v["@graph"]; ok {
+			entities = collectEntities(graph, entities)
+		}
+		if _, ok := v["@type"]; ok {
+			entities = append(entities, v)
+		}
+	}
+	return entities
+}
+
+// microdataItem reads an itemscope element into the same shape as a JSON-LD entity
+func (c *Crawler) microdataItem(n *html.Node) map[string]any {
+	item := map[string]any{"@type": schemaType(c.getAttributeValue(n, "itemtype"))}
+
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+
+			prop := c.getAttributeValue(child, "itemprop")
+			if prop == "" {
+				if !c.hasAttribute(child, "itemscope") {
+					walk(child)
+				}
+				continue
+			}
+
+			var value any
+			if c.hasAttribute(child, "itemscope") {
+				value = c.microdataItem(child)
+			} else {
+				value = c.microdataValue(child)
+				walk(child)
+			}
+
+			// an element can carry several space separated properties
+			for _, name := range strings.Fields(prop) {
+				switch existing := item[name].(type) {
+				case nil:
+					item[name] = value
+				case []any:
+					item[name] = append(existing, value)
+				default:
+					item[name] = []any{existing, value}
+				}
+			}
+		}
+	}
+	walk(n)
+
+	return item
+}
+
+func (c *Crawler) microdataValue(n *html.Node) string {
+	switch n.Data {
+	case "meta":
+		return c.getAttributeValue(n, "content")
+	case "a", "link", "area":
+		return c.getAttributeValue(n, "href")
+	case "img", "audio", "video", "source", "embed", "iframe":
+		return c.getAttributeValue(n, "src")
+	case "object":
+		return c.getAttributeValue(n, "data")
+	case "time":
+		if datetime := c.getAttributeValue(n, "datetime"); datetime != "" {
+			return datetime
+		}
+	case "data", "meter":
+		return c.getAttributeValue(n, "value")
+	}
+	if content := c.getAttributeValue(n, "content"); content != "" {
+		return content
+	}
+	return normalizeSpace(c.extractTextContent(n))
+}
+
+// schemaType turns "https://schema.org/Product" or ["Product", "Thing"] into "Product"
+func schemaType(value any) string {
+	switch v := value.(type) {
+	case string:
+		fields := strings.Fields(v)
+		if len(fields) == 0 {
+			return ""
+		}
+		t := strings.TrimRight(fields[0], "/")
+		if i := strings.LastIndexAny(t, "/#:"); i >= 0 {
+			t = t[i+1:]
+		}
+		return t
+	case []any:
+		for _, item := range v {
+			if t := schemaType(item); t != "" {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+func structuredDataFromEntities(entities []map[string]any) models.StructuredData {
+	var data models.StructuredData
+
+	var main map[string]any
+	mainRank := 0
+	for _, entity := range entities {
+		entityType := schemaType(entity["@type"])
+
+		switch entityType {
+		case "BreadcrumbList":
+			if len(data.Breadcrumb) == 0 {
+				data.Breadcrumb = breadcrumbItems(entity["itemListElement"])
+			}
+		case "FAQPage":
+			if len(data.FAQ) == 0 {
+				data.FAQ = faqItems(entity["mainEntity"])
+			}
+		}
+
+		if entityType == "" || supportingEntities[entityType] {
+			continue
+		}
+		rank, ok := entityRank[entityType]
+		if !ok {
+			rank = 5
+		}
+		if main == nil || rank < mainRank {
+			main, mainRank = entity, rank
+		}
+	}
+
+	if main == nil {
+		return data
+	}
+
+	data.Type = schemaType(main["@type"])
+	data.Name = firstString(main, "name", "headline")
+	data.Description = ldString(main["description"])
+	data.Authors = ldStrings(main["author"])
+	if len(data.Authors) == 0 {
+		data.Authors = ldStrings(main["creator"])
+	}
+	data.DatePublished = firstString(main, "datePublished", "uploadDate", "dateCreated", "startDate")
+	data.DateModified = ldString(main["dateModified"])
+	data.Images = ldURLs(main["image"])
+	if len(data.Images) == 0 {
+		data.Images = ldURLs(main["thumbnailUrl"])
+	}
+
+	if data.Type == "Product" {
+		data.Product = productInfo(main)
+	}
+
+	return data
+}
+
+func productInfo(entity map[string]any) *models.ProductInfo {
+	product := &models.ProductInfo{
+		Brand: ldString(entity["brand"]),
+		SKU:   firstString(entity, "sku", "gtin13", "gtin", "mpn"),
+	}
+
+	if offer := firstMap(entity["offers"]); offer != nil {
+		product.Price = firstString(offer, "price", "lowPrice")
+		if product.Price == "" {
+			if spec := firstMap(offer["priceSpecification"]); spec != nil {
+				product.Price = ldString(spec["price"])
+				product.Currency = ldString(spec["priceCurrency"])
+			}
+		}
+		if currency := ldString(offer["priceCurrency"]); currency != "" {
+			product.Currency = currency
+		}
+		product.Availability = schemaType(ldString(offer["availability"]))
+	}
+
+	if rating := firstMap(entity["aggregateRating"]); rating != nil {
+		product.Rating, _ = strconv.ParseFloat(ldString(rating["ratingValue"]), 64)
+		product.ReviewCount, _ = strconv.Atoi(firstString(rating, "reviewCount", "ratingCount"))
+	}
+
+	return product
+}
+
+func breadcrumbItems(value any) []models.BreadcrumbItem {
+	type positioned struct {
+		position int
+		item     models.BreadcrumbItem
+	}
+
+	elements := make([]positioned, 0)
+	for i, element := range ldList(value) {
+		entry, ok := element.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		position, err := strconv.Atoi(ldString(entry["position"]))
+		if err != nil {
+			position = i + 1
+		}
+
+		crumb := models.BreadcrumbItem{Name: ldString(entry["name"])}
+		switch item := entry["item"].(type) {
+		case string:
+			crumb.URL = item
+		case map[string]any:
+			crumb.URL = firstString(item, "@id", "url")
+			if crumb.Name == "" {
+				crumb.Name = ldString(item["name"])
+			}
+		}
+
+		if crumb.Name != "" {
+			elements = append(elements, positioned{position: position, item: crumb})
+		}
+	}
+
+	sort.SliceStable(elements, func(i, j int) bool { return elements[i].position < elements[j].position })
+
+	items := make([]models.BreadcrumbItem, len(elements))
+	for i, element := range elements {
+		items[i] = element.item
+	}
+	return items
+}
+
+func faqItems(value any) []models.FAQItem {
+	items := make([]models.FAQItem, 0)
+	for _, element := range ldList(value) {
+		question, ok := element.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		item := models.FAQItem{Question: ldString(question["name"])}
+		if answer := firstMap(question["acceptedAnswer"]); answer != nil {
+			// answers are often html fragments
+			item.Answer = normalizeSpace(html.UnescapeString(htmlTags.ReplaceAllString(ldString(answer["text"]), " ")))
+		}
+		if item.Question != "" && item.Answer != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// fillFromSocialTags uses OpenGraph and Twitter cards for what the schema.org entities did not say
+func fillFromSocialTags(data *models.StructuredData) {
+	og := data.OpenGraph
+	tw := data.Twitter
+
+	if data.Type == "" {
+		data.Type = openGraphTypes[strings.ToLower(og["og:type"])]
+	}
+	if data.Name == "" {
+		data.Name = firstNonEmpty(og["og:title"], tw["twitter:title"])
+	}
+	if data.Description == "" {
+		data.Description = firstNonEmpty(og["og:description"], tw["twitter:description"])
+	}
+	if len(data.Images) == 0 {
+		if image := firstNonEmpty(og["og:image"], og["og:image:url"], tw["twitter:image"]); image != "" {
+			data.Images = []string{image}
+		}
+	}
+	if data.DatePublished == "" {
+		data.DatePublished = og["article:published_time"]
+	}
+	if data.DateModified == "" {
+		data.DateModified = firstNonEmpty(og["article:modified_time"], og["og:updated_time"])
+	}
+	if len(data.Authors) == 0 {
+		if author := firstNonEmpty(og["article:author"], og["book:author"], tw["twitter:creator"]); author != "" {
+			data.Authors = []string{author}
+		}
+	}
+	if data.Type == "Product" && data.Product == nil {
+		if price := firstNonEmpty(og["product:price:amount"], og["og:price:amount"]); price != "" {
+			data.Product = &models.ProductInfo{
+				Price:    price,
+				Currency: firstNonEmpty(og["product:price:currency"], og["og:price:currency"]),
+			}
+		}
+	}
+}
+
+// ldString reads a JSON-LD value as text, objects give their name (or id) and lists their first value
+func ldString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return normalizeSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]any:
+		return firstString(v, "name", "@value", "url", "@id")
+	case []any:
+		for _, item := range v {
+			if s := ldString(item); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+func ldStrings(value any) []string {
+	values := make([]string, 0)
+	for _, item := range ldList(value) {
+		if s := ldString(item); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// ldURLs reads images and other media, objects give their url instead of their name
+func ldURLs(value any) []string {
+	urls := make([]string, 0)
+	for _, item := range ldList(value) {
+		url := ""
+		switch v := item.(type) {
+		case string:
+			url = v
+		case map[string]any:
+			url = firstString(v, "url", "contentUrl", "@id")
+		}
+		if url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+func ldList(value any) []any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []any:
+		return v
+	default:
+		return []any{v}
+	}
+}
+
+func firstMap(value any) map[string]any {
+	for _, item := range ldList(value) {
+		if m, ok := item.(map[string]any); ok {
+			return m
+		}
+	}
+	return nil
+}
+
+func firstString(entity map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if s := ldString(entity[key]); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
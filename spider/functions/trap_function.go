@@ -0,0 +1,415 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/froxy/config"
+	"github.com/froxy/db"
+	"github.com/froxy/models"
+	"github.com/froxy/utils"
+)
+
+const (
+	// a path segment found this many times in one url is a loop (/a/b/a/b/a/b)
+	maxSegmentRepeats = 3
+	// links in a row that only extended the url of the page they were found on
+	maxURLGrowth = 10
+	// patterns followed per host, past that new patterns only get the per-url checks
+	maxTrackedPatterns = 10000
+	// pages of a pattern kept to compare the next ones to
+	maxContentSamples = 5
+	// pages of a pattern compared before its content can get it blocked
+	minContentSamples = 10
+	// share of near-duplicate pages that blocks a pattern
+	duplicateContentShare = 0.9
+	trapSaveInterval      = 30 * time.Second
+)
+
+var (
+	maxURLLength         = config.Default().Traps.MaxURLLength
+	maxURLsPerPattern    = config.Default().Traps.MaxURLsPerPattern
+	maxParamCombinations = config.Default().Traps.MaxParamCombinations
+)
+
+// patternState is what we know of the urls of one pattern of a host
+type patternState struct {
+	urls    int
+	blocked bool
+	// simhashes of the first crawled pages, and how many later pages looked like them
+	samples  []uint64
+	compared int
+	similar  int
+}
+
+// pathParams are the sets of query parameters seen on one path pattern
+type pathParams struct {
+	sets   map[string]struct{}
+	capped bool
+}
+
+type hostTraps struct {
+	patterns map[string]*patternState
+	paths    map[string]*pathParams
+	traps    map[string]*models.CrawlTrap
+}
+
+// trapDetector keeps the crawl out of infinite url spaces: calendars, faceted navigation, session ids,
+// relative links that keep growing the path. Urls are grouped by pattern, the url with its ids and query
+// values left out, and the patterns that keep producing urls or the same content are capped or blocked.
+type trapDetector struct {
+	mu    sync.Mutex
+	hosts map[string]*hostTraps
+	// traps changed since the last save
+	dirty map[*models.CrawlTrap]struct{}
+}
+
+func newTrapDetector() *trapDetector {
+	return &trapDetector{
+		hosts: make(map[string]*hostTraps),
+		dirty: make(map[*models.CrawlTrap]struct{}),
+	}
+}
+
+func (d *trapDetector) host(host string) *hostTraps {
+	state, ok := d.hosts[host]
+	if !ok {
+		state = &hostTraps{
+			patterns: make(map[string]*patternState),
+			paths:    make(map[string]*pathParams),
+			traps:    make(map[string]*models.CrawlTrap),
+		}
+		d.hosts[host] = state
+	}
+	return state
+}
+
+// urlPatterns returns the pattern of the path, ids and numbers replaced by "*", and the pattern of the url,
+// which adds the sorted names of the query parameters
+func urlPatterns(parsed *url.URL) (string, string) {
+	segments := strings.Split(strings.Trim(parsed.EscapedPath(), "/"), "/")
+	for i, segment := range segments {
+		if variableSegment(segment) {
+			segments[i] = "*"
+		}
+	}
+	pathPattern := "/" + strings.Join(segments, "/")
+
+	query := parsed.Query()
+	if len(query) == 0 {
+		return pathPattern, pathPattern
+	}
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return pathPattern, pathPattern + "?" + strings.Join(names, "&")
+}
+
+// variableSegment tells the path segments that are ids, dates or page numbers rather than sections:
+// mostly digits, a run of four digits or more (years, the id at the end of a slug) or a long hex id.
+// Names with a digit in them (v1, html5, go1.22) are sections.
+func variableSegment(segment string) bool {
+	if len(segment) > 40 {
+		return true
+	}
+
+	digits, run, longestRun := 0, 0, 0
+	hexID := len(segment) >= 16
+	for _, r := range segment {
+		if r >= '0' && r <= '9' {
+			digits++
+			run++
+			longestRun = max(longestRun, run)
+		} else {
+			run = 0
+		}
+		if !strings.ContainsRune("0123456789abcdefABCDEF-", r) {
+			hexID = false
+		}
+	}
+	return digits*2 > len(segment) || longestRun >= 4 || hexID && digits > 0
+}
+
+// sectionOf keeps the first two segments of a path pattern, the urls suppressed by the per-url checks
+// are counted per section
+func sectionOf(pathPattern string) string {
+	segments := strings.SplitN(strings.TrimPrefix(pathPattern, "/"), "/", 3)
+	if len(segments) > 2 {
+		return "/" + strings.Join(segments[:2], "/") + "/..."
+	}
+	return pathPattern
+}
+
+// repeatsSegment reports whether a segment comes back too often in the path
+func repeatsSegment(path string) bool {
+	counts := make(map[string]int)
+	for _, segment := range strings.Split(strings.ToLower(path), "/") {
+		if segment == "" {
+			continue
+		}
+		counts[segment]++
+		if counts[segment] >= maxSegmentRepeats {
+			return true
+		}
+	}
+	return false
+}
+
+// urlGrowth counts the links in a row whose url extends the url of the page they were found on
+func urlGrowth(from models.Link, rawURL string) int {
+	if len(rawURL) > len(from.URL) && strings.HasPrefix(rawURL, strings.TrimSuffix(from.URL, "/")) {
+		return from.Growth + 1
+	}
+	return 0
+}
+
+// admit counts a new url against the pattern limits of its host.
+// It returns why the url is suppressed, empty when it may be queued.
+func (d *trapDetector) admit(link models.Link) string {
+	parsed, err := url.Parse(link.URL)
+	if err != nil {
+		return ""
+	}
+	pathPattern, pattern := urlPatterns(parsed)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state := d.host(parsed.Host)
+	switch {
+	case len(link.URL) > maxURLLength:
+		return d.suppress(parsed.Host, sectionOf(pathPattern), models.TrapURLLength, link.URL)
+	case repeatsSegment(parsed.Path):
+		return d.suppress(parsed.Host, sectionOf(pathPattern), models.TrapRepeatingSegments, link.URL)
+	case link.Growth > maxURLGrowth:
+		return d.suppress(parsed.Host, sectionOf(pathPattern), models.TrapURLGrowth, link.URL)
+	}
+
+	patternURLs, tracked := state.patterns[pattern]
+	if !tracked {
+		if len(state.patterns) >= maxTrackedPatterns {
+			return ""
+		}
+		patternURLs = &patternState{}
+		state.patterns[pattern] = patternURLs
+	}
+	switch {
+	case patternURLs.blocked:
+		return d.suppress(parsed.Host, pattern, models.TrapDuplicateContent, link.URL)
+	case patternURLs.urls >= maxURLsPerPattern:
+		return d.suppress(parsed.Host, pattern, models.TrapPatternCap, link.URL)
+	}
+
+	if pattern != pathPattern {
+		params, ok := state.paths[pathPattern]
+		if !ok {
+			params = &pathParams{sets: make(map[string]struct{})}
+			state.paths[pathPattern] = params
+		}
+		if _, seen := params.sets[pattern]; !seen {
+			if params.capped || len(params.sets) >= maxParamCombinations {
+				params.capped = true
+				return d.suppress(parsed.Host, pathPattern+"?*", models.TrapParamCombinations, link.URL)
+			}
+			params.sets[pattern] = struct{}{}
+		}
+	}
+
+	patternURLs.urls++
+	return ""
+}
+
+// release gives back the room taken by admit for an url that was not queued after all
+func (d *trapDetector) release(rawURL string) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	_, pattern := urlPatterns(parsed)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if state, ok := d.hosts[parsed.Host]; ok {
+		if patternURLs, ok := state.patterns[pattern]; ok && patternURLs.urls > 0 {
+			patternURLs.urls--
+		}
+	}
+}
+
+// trap returns the trap of a pattern and logs it when it is new or gets blocked, d.mu must be held
+func (d *trapDetector) trap(host, pattern, reason string) *models.CrawlTrap {
+	state := d.host(host)
+	trap, ok := state.traps[pattern]
+	if !ok {
+		trap = &models.CrawlTrap{Host: host, Pattern: pattern, Reason: reason, DetectedAt: time.Now()}
+		state.traps[pattern] = trap
+	} else if trap.Blocked || reason != models.TrapDuplicateContent {
+		return trap
+	}
+
+	trap.Reason = reason
+	trap.Blocked = reason == models.TrapDuplicateContent
+	d.dirty[trap] = struct{}{}
+	log.Printf("Crawler trap on %s: %s (%s), suppressing its urls", host, pattern, reason)
+	appendLog(fmt.Sprintf("Crawler trap on %s: %s (%s), suppressing its urls", host, pattern, reason))
+	return trap
+}
+
+// suppress counts an url that is not crawled against its trap, d.mu must be held
+func (d *trapDetector) suppress(host, pattern, reason, rawURL string) string {
+	trap := d.trap(host, pattern, reason)
+	trap.Suppressed++
+	d.dirty[trap] = struct{}{}
+	log.Printf("Suppressed %s: %s", rawURL, reason)
+	return reason
+}
+
+// blocked reports whether a queued url belongs to a pattern blocked since it was queued
+func (d *trapDetector) blocked(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	_, pattern := urlPatterns(parsed)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.hosts[parsed.Host]
+	if !ok {
+		return false
+	}
+	patternURLs, ok := state.patterns[pattern]
+	if !ok || !patternURLs.blocked {
+		return false
+	}
+	d.suppress(parsed.Host, pattern, models.TrapDuplicateContent, rawURL)
+	return true
+}
+
+// observe compares a crawled page to the first pages of its pattern, a pattern whose pages are almost
+// all near-duplicates (empty calendar days, sorted copies of a listing) is blocked
+func (d *trapDetector) observe(rawURL string, simHash uint64) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	_, pattern := urlPatterns(parsed)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.hosts[parsed.Host]
+	if !ok {
+		return
+	}
+	patternURLs, ok := state.patterns[pattern]
+	if !ok || patternURLs.blocked {
+		return
+	}
+
+	if len(patternURLs.samples) > 0 {
+		patternURLs.compared++
+		for _, sample := range patternURLs.samples {
+			if utils.HammingDistance(sample, simHash) <= nearDuplicateDistance {
+				patternURLs.similar++
+				break
+			}
+		}
+	}
+	if len(patternURLs.samples) < maxContentSamples {
+		patternURLs.samples = append(patternURLs.samples, simHash)
+	}
+
+	if patternURLs.compared >= minContentSamples &&
+		float64(patternURLs.similar) >= float64(patternURLs.compared)*duplicateContentShare {
+		patternURLs.blocked = true
+		log.Printf("%d of %d pages of %s%s are near-duplicates", patternURLs.similar, patternURLs.compared, parsed.Host, pattern)
+		d.trap(parsed.Host, pattern, models.TrapDuplicateContent)
+	}
+}
+
+// restore applies the traps found by previous crawls. Only the patterns blocked for their duplicate content
+// stay blocked, the caps start over with every run. It returns how many patterns are blocked.
+func (d *trapDetector) restore(traps []models.CrawlTrap) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	blocked := 0
+	for _, trap := range traps {
+		if !trap.Blocked || trap.Reason != models.TrapDuplicateContent {
+			continue
+		}
+		state := d.host(trap.Host)
+		state.traps[trap.Pattern] = &trap
+		state.patterns[trap.Pattern] = &patternState{blocked: true}
+		blocked++
+	}
+	return blocked
+}
+
+// save writes the traps that changed to crawl_traps, they are kept for the next save when it fails
+func (d *trapDetector) save() {
+	d.mu.Lock()
+	changed := make([]*models.CrawlTrap, 0, len(d.dirty))
+	batch := make([]models.CrawlTrap, 0, len(d.dirty))
+	for trap := range d.dirty {
+		changed = append(changed, trap)
+		batch = append(batch, *trap)
+	}
+	d.dirty = make(map[*models.CrawlTrap]struct{})
+	d.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := db.GetPostgresHandler().SaveTraps(batch); err != nil {
+		log.Printf("Failed to save %d crawler traps: %v", len(batch), err)
+
+		d.mu.Lock()
+		for _, trap := range changed {
+			d.dirty[trap] = struct{}{}
+		}
+		d.mu.Unlock()
+	}
+}
+
+// run saves the traps at regular intervals until the context is done
+func (d *trapDetector) run(ctx context.Context) {
+	ticker := time.NewTicker(trapSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.save()
+		}
+	}
+}
+
+// restoreTraps loads the traps of previous crawls so a resumed crawl does not fall into them again
+func (c *Crawler) restoreTraps() {
+	traps, err := db.GetPostgresHandler().LoadTraps()
+	if err != nil {
+		log.Printf("Failed to load crawler traps: %v", err)
+		return
+	}
+	blocked := c.traps.restore(traps)
+
+	if blocked > 0 {
+		log.Printf("Loaded %d blocked crawler traps", blocked)
+		appendLog(fmt.Sprintf("Loaded %d blocked crawler traps", blocked))
+	}
+}
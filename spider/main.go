@@ -1,45 +1,234 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"os"
+	"text/tabwriter"
 	"time"
 
+	"github.com/froxy/config"
 	"github.com/froxy/db"
 	"github.com/froxy/functions"
+	"github.com/froxy/utils"
 	"github.com/joho/godotenv"
 )
 
+const usage = `usage: spider <command> [flags]
+
+commands:
+  crawl     crawl from seed urls (arguments, -seeds-file, stdin or the config file)
+  recrawl   revisit the stored pages as they become due, runs until stopped
+  status    print what is stored and what is left in the frontier
+  errors    summarize the failed fetches per host
+
+run "spider <command> -h" for the flags of a command`
+
 func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	// the .env file is optional, the variables can come from the environment itself
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Println(err)
+		return 1
+	}
+
+	var err error
+	switch args[0] {
+	case "crawl":
+		err = crawlCommand(args[1:])
+	case "recrawl":
+		err = recrawlCommand(args[1:])
+	case "status":
+		err = statusCommand(args[1:])
+	case "errors":
+		err = errorsCommand(args[1:])
+	case "-h", "-help", "--help", "help":
+		fmt.Println(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", args[0], usage)
+		return 2
+	}
+
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		log.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func crawlCommand(args []string) error {
+	cfg, seeds, err := parseCrawlFlags(args)
+	if err != nil {
+		return err
+	}
+	if len(seeds) == 0 {
+		return fmt.Errorf("no seed urls, pass them as arguments, with -seeds-file or on stdin")
+	}
+
+	crawler, err := startCrawler(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.GetPostgresHandler().GracefulShutdown(time.Second * 5)
+	defer crawler.VisitedUrls.Close()
 
 	fmt.Println("starting spider bot")
-	err := godotenv.Load()
+	crawler.StartSeeds(cfg.Workers, seeds...)
+	return nil
+}
+
+func recrawlCommand(args []string) error {
+	cfg, err := parseRecrawlFlags(args)
 	if err != nil {
-		log.Panic(err)
-		return
+		return err
 	}
 
-	err = db.InitQdrant()
+	crawler, err := startCrawler(cfg)
 	if err != nil {
-		log.Panic(err)
-		return
+		return err
+	}
+	defer db.GetPostgresHandler().GracefulShutdown(time.Second * 5)
+	defer crawler.VisitedUrls.Close()
+
+	fmt.Println("starting spider bot in recrawl mode")
+	crawler.Recrawl(cfg.Workers)
+	return nil
+}
+
+func statusCommand(args []string) error {
+	if err := parseStatusFlags(args); err != nil {
+		return err
 	}
 
-	err = db.InitPostgres(db.Client)
+	// counting rows does not need Qdrant
+	if err := db.InitPostgres(nil); err != nil {
+		return err
+	}
+	defer db.GetPostgresHandler().GracefulShutdown(time.Second * 5)
+
+	status, err := db.GetPostgresHandler().CrawlStatus()
 	if err != nil {
-		log.Println(err)
-		return
+		return err
+	}
+
+	fmt.Printf("pages:            %d\n", status.Pages)
+	fmt.Printf("  indexed:        %d\n", status.Indexed)
+	fmt.Printf("  duplicates:     %d\n", status.Duplicates)
+	fmt.Printf("  due to recrawl: %d\n", status.DueForRecrawl)
+	fmt.Printf("url aliases:      %d\n", status.Aliases)
+	fmt.Printf("crawler traps:    %d\n", status.Traps)
+	if !status.LastCrawl.IsZero() {
+		fmt.Printf("last crawl:       %s\n", status.LastCrawl.Format(time.RFC3339))
+	}
+	fmt.Println("frontier:")
+	for _, frontierStatus := range []string{db.FrontierQueued, db.FrontierInProgress, db.FrontierDone, db.FrontierFailed, db.FrontierSpilled} {
+		fmt.Printf("  %-15s %d\n", frontierStatus+":", status.Frontier[frontierStatus])
+	}
+	return nil
+}
+
+func errorsCommand(args []string) error {
+	flags, err := parseErrorsFlags(args)
+	if err != nil {
+		return err
 	}
 
+	if err := db.InitPostgres(nil); err != nil {
+		return err
+	}
 	defer db.GetPostgresHandler().GracefulShutdown(time.Second * 5)
 
-	crawler := functions.NewCrawler()
+	since := time.Now().Add(-flags.since)
+	summary, err := db.GetPostgresHandler().HostErrorSummary(flags.host, since, flags.limit)
+	if err != nil {
+		return err
+	}
+	if len(summary) == 0 {
+		fmt.Printf("no failed fetches since %s\n", since.Format(time.RFC3339))
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "HOST\tOUTCOME\tCOUNT\tLAST SEEN")
+	for _, row := range summary {
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\n", row.Host, row.Outcome, row.Count, row.LastSeen.Format(time.RFC3339))
+	}
+	writer.Flush()
 
-	var crawlableSites = []string{}
+	if flags.host == "" {
+		return nil
+	}
+
+	attempts, err := db.GetPostgresHandler().FailedAttempts(flags.host, since, flags.limit)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	writer = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "TIME\tURL\tOUTCOME\tSTATUS\tPROXY\tERROR")
+	for _, attempt := range attempts {
+		status, proxy := "-", "direct"
+		if attempt.StatusCode != 0 {
+			status = fmt.Sprint(attempt.StatusCode)
+		}
+		if attempt.Proxy != "" {
+			proxy = attempt.Proxy
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", attempt.AttemptedAt.Format(time.RFC3339), attempt.URL,
+			attempt.Outcome, status, proxy, attempt.Error)
+	}
+	return writer.Flush()
+}
+
+// startCrawler connects the databases and builds a crawler with the settings
+func startCrawler(cfg config.Config) (*functions.Crawler, error) {
+	if err := functions.Configure(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := db.InitQdrant(); err != nil {
+		return nil, err
+	}
+	if err := db.InitPostgres(db.Client); err != nil {
+		return nil, err
+	}
 
-	crawler.Start(
-		5,
-		crawlableSites...,
-	)
+	crawler := functions.NewCrawler()
+	crawler.Scope = cfg.Scope
+
+	// large crawls keep the visited urls on disk
+	if cfg.SeenSetPath != "" {
+		seen, err := utils.OpenDiskSeenSet(cfg.SeenSetPath)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d visited urls from %s", seen.Len(), cfg.SeenSetPath)
+		crawler.VisitedUrls = seen
+	}
 
+	if cfg.Focus.Topic != "" {
+		if err := crawler.Focus(cfg.Focus); err != nil {
+			return nil, err
+		}
+	}
+	return crawler, nil
 }
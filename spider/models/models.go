@@ -5,6 +5,42 @@ import "time"
 type Link struct {
 	Text string `json:"text"`
 	URL  string `json:"url"`
+	// sitemap hints, zero when the link was found in a page
+	Priority   float64   `json:"priority,omitempty"`
+	LastMod    time.Time `json:"lastmod,omitempty"`
+	ChangeFreq string    `json:"changefreq,omitempty"`
+	// number of links followed from the seed, seeds are at depth 0
+	Depth int `json:"depth,omitempty"`
+	// pages found linking to the url while it was queued
+	Inlinks int `json:"inlinks,omitempty"`
+	// similarity to the topic of a focused crawl, from the anchor text and the page the link was found on
+	Relevance float64 `json:"relevance,omitempty"`
+	// links in a row that extended the url of the page they were found on, not persisted
+	Growth int `json:"-"`
+}
+
+// ScopeConfig limits what a crawl started from a seed may visit, zero values mean no limit
+type ScopeConfig struct {
+	// regular expressions matched against the whole url, when set a url must match one of them
+	Allow []string `json:"allow" yaml:"allow"`
+	// urls matching any of these are never queued
+	Deny              []string `json:"deny" yaml:"deny"`
+	IncludeSubdomains bool     `json:"include_subdomains" yaml:"include_subdomains"`
+	MaxDepth          int      `json:"max_depth" yaml:"max_depth"`
+	MaxPagesPerHost   int      `json:"max_pages_per_host" yaml:"max_pages_per_host"`
+	MaxPages          int      `json:"max_pages" yaml:"max_pages"`
+}
+
+// IsEmpty reports whether the scope sets no rule at all
+func (s ScopeConfig) IsEmpty() bool {
+	return len(s.Allow) == 0 && len(s.Deny) == 0 && !s.IncludeSubdomains &&
+		s.MaxDepth == 0 && s.MaxPagesPerHost == 0 && s.MaxPages == 0
+}
+
+// Seed is a starting url with the scope of the crawl it starts
+type Seed struct {
+	URL   string      `json:"url" yaml:"url"`
+	Scope ScopeConfig `json:"scope" yaml:"scope"`
 }
 type PageData struct {
 	URL             string              `json:"url"`
@@ -13,19 +49,218 @@ type PageData struct {
 	MetaKeywords    string              `json:"meta_keywords"`
 	Language        string              `json:"language"`
 	Canonical       string              `json:"canonical"`
+	MetaRefresh     string              `json:"meta_refresh"`
+	RedirectChain   []string            `json:"redirect_chain"`
+	Aliases         []URLAlias          `json:"aliases"`
 	Headings        map[string][]string `json:"headings"`
 	MainContent     string              `json:"main_content"`
+	Blocks          []ContentBlock      `json:"blocks"`
+	Markdown        string              `json:"markdown"`
 	ImageAlt        []string            `json:"image_alt"`
 	LinkText        []string            `json:"link_text"`
 	WordCount       int                 `json:"word_count"`
 	StatusCode      int                 `json:"status_code"`
 	ResponseTime    time.Duration       `json:"response_time"`
+	TimeToFirstByte time.Duration       `json:"time_to_first_byte"`
 	ContentType     string              `json:"content_type"`
+	Encoding        string              `json:"encoding"`
+	PageCount       int                 `json:"page_count"`
 	CrawlDate       time.Time           `json:"crawl_date"`
 	LastModified    time.Time           `json:"last_modified"`
 	OutboundLinks   []Link              `json:"out_links"`
 	InCommingLinks  []Link              `json:"in_links"`
 	Favicon         string              `json:"favicon"`
+	ETag            string              `json:"etag"`
+	ContentHash     string              `json:"content_hash"`
+	RecrawlInterval time.Duration       `json:"recrawl_interval"`
+	SimHash         uint64              `json:"simhash"`
+	DuplicateOf     string              `json:"duplicate_of"`
+	StructuredData  StructuredData      `json:"structured_data"`
+	PublishedAt     time.Time           `json:"published_at"`
+	ModifiedAt      time.Time           `json:"modified_at"`
+	Authors         []string            `json:"authors"`
+	// set when the page was already embedded (focused crawl), the Qdrant upsert reuses it
+	Embedding []float32 `json:"-"`
+}
+
+// EmbeddingText is the text the page is embedded from, the markdown keeps headings, lists, tables and code
+func (p PageData) EmbeddingText() string {
+	if p.Markdown != "" {
+		return p.Markdown
+	}
+	return p.MainContent
+}
+
+// Block types of the extracted main content
+const (
+	BlockHeading   = "heading"
+	BlockParagraph = "paragraph"
+	BlockList      = "list"
+	BlockQuote     = "quote"
+	BlockCode      = "code"
+	BlockTable     = "table"
+)
+
+// ContentBlock is one structural piece of the main content (a paragraph, a list, a table...)
+type ContentBlock struct {
+	Type  string `json:"type"`
+	Level int    `json:"level,omitempty"` // heading level
+	Text  string `json:"text"`
+}
+
+// Why a url is an alias of another one
+const (
+	AliasRedirect  = "redirect"
+	AliasCanonical = "canonical"
+	AliasRefresh   = "refresh"
+)
+
+// URLAlias is another url serving the same document, it is not stored as a page of its own
+type URLAlias struct {
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
+}
+
+// StructuredData is what the page tells about itself through JSON-LD, microdata,
+// OpenGraph and Twitter cards, normalized around its main schema.org entity
+type StructuredData struct {
+	Type          string            `json:"type,omitempty"` // Article, Product, FAQPage, Recipe...
+	Name          string            `json:"name,omitempty"`
+	Description   string            `json:"description,omitempty"`
+	Authors       []string          `json:"authors,omitempty"`
+	DatePublished string            `json:"date_published,omitempty"`
+	DateModified  string            `json:"date_modified,omitempty"`
+	Images        []string          `json:"images,omitempty"`
+	Breadcrumb    []BreadcrumbItem  `json:"breadcrumb,omitempty"`
+	Product       *ProductInfo      `json:"product,omitempty"`
+	FAQ           []FAQItem         `json:"faq,omitempty"`
+	OpenGraph     map[string]string `json:"open_graph,omitempty"`
+	Twitter       map[string]string `json:"twitter,omitempty"`
+}
+
+type BreadcrumbItem struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+type ProductInfo struct {
+	Brand        string  `json:"brand,omitempty"`
+	SKU          string  `json:"sku,omitempty"`
+	Price        string  `json:"price,omitempty"`
+	Currency     string  `json:"currency,omitempty"`
+	Availability string  `json:"availability,omitempty"`
+	Rating       float64 `json:"rating,omitempty"`
+	ReviewCount  int     `json:"review_count,omitempty"`
+}
+
+type FAQItem struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// IsEmpty reports whether the page had no structured data at all
+func (s StructuredData) IsEmpty() bool {
+	return s.Type == "" && s.Name == "" && len(s.Authors) == 0 && s.DatePublished == "" &&
+		len(s.Images) == 0 && len(s.Breadcrumb) == 0 && s.Product == nil && len(s.FAQ) == 0 &&
+		len(s.OpenGraph) == 0 && len(s.Twitter) == 0
+}
+
+// PageFreshness is what we remember about a stored page to recrawl it conditionally
+type PageFreshness struct {
+	URL             string
+	ETag            string
+	LastModified    time.Time
+	ContentHash     string
+	RecrawlInterval time.Duration
+}
+
+// How a fetch failed
+const (
+	OutcomeOK               = "ok"
+	OutcomeDNS              = "dns"
+	OutcomeTimeout          = "timeout"
+	OutcomeConnection       = "connection"
+	OutcomeClientError      = "client_error"
+	OutcomeServerError      = "server_error"
+	OutcomeRateLimited      = "rate_limited"
+	OutcomeUnexpectedStatus = "unexpected_status"
+)
+
+// FailedURL is a fetch that failed, transient failures are retried in a later pass
+type FailedURL struct {
+	Link       Link
+	Outcome    string
+	StatusCode int
+	Error      string
+	Retry      bool
+	// the server asked us to wait at least this long (Retry-After)
+	RetryAfter time.Duration
+}
+
+// FetchAttempt is one request for a page, stored in the fetch_attempts table
+type FetchAttempt struct {
+	URL          string
+	Host         string
+	StatusCode   int
+	Outcome      string
+	Error        string
+	ResponseTime time.Duration
+	// from asking for a connection to the first response byte, zero when nothing came back
+	TimeToFirstByte time.Duration
+	// redacted url of the proxy, empty for a direct request
+	Proxy       string
+	AttemptedAt time.Time
+}
+
+// HostErrors counts the failed attempts of a host for one outcome
+type HostErrors struct {
+	Host     string
+	Outcome  string
+	Count    int
+	LastSeen time.Time
+}
+
+// Why a url pattern was found to be a crawler trap
+const (
+	TrapRepeatingSegments = "repeating_segments"
+	TrapURLLength         = "url_length"
+	TrapURLGrowth         = "url_growth"
+	TrapPatternCap        = "pattern_cap"
+	TrapParamCombinations = "param_combinations"
+	TrapDuplicateContent  = "duplicate_content"
+)
+
+// CrawlTrap is an url pattern of a host the crawler stopped following, stored in the crawl_traps table.
+// The urls of a blocked pattern are not crawled at all, even the queued ones, the other patterns are capped.
+type CrawlTrap struct {
+	Host       string
+	Pattern    string
+	Reason     string
+	Blocked    bool
+	Suppressed int
+	DetectedAt time.Time
+}
+
+// RetryPolicy spaces the later passes over a failed url: the delay doubles with every failure,
+// within [BaseDelay, MaxDelay], and the url is given up after MaxAttempts failures
+type RetryPolicy struct {
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int
+}
+
+// CrawlStatus sums up what the spider stored so far
+type CrawlStatus struct {
+	Pages         int
+	Indexed       int
+	Duplicates    int
+	DueForRecrawl int
+	Aliases       int
+	LastCrawl     time.Time
+	// crawl_frontier urls per status
+	Frontier map[string]int
+	// url patterns found to be crawler traps
+	Traps int
 }
 
 type EmbeddingModel struct {
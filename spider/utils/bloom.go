@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+)
+
+// BloomFilter is a scalable Bloom filter. When a stage holds as many keys as it was sized for,
+// a stage twice as big with half the error rate is added, so the false positive rate stays
+// under the target however many keys end up in the filter.
+type BloomFilter struct {
+	mu        sync.RWMutex
+	stages    []*bloomStage
+	errorRate float64
+	seeds     [2]maphash.Seed
+}
+
+type bloomStage struct {
+	bits     []uint64
+	size     uint64 // number of bits
+	hashes   int
+	capacity int
+	count    int
+}
+
+// NewBloomFilter sizes the first stage for capacity keys, errorRate is the target false positive rate
+func NewBloomFilter(capacity int, errorRate float64) *BloomFilter {
+	filter := &BloomFilter{
+		errorRate: errorRate,
+		seeds:     [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+	}
+	// the error rates of the stages add up to errorRate: errorRate/2 + errorRate/4 + ...
+	filter.stages = append(filter.stages, newBloomStage(capacity, errorRate/2))
+	return filter
+}
+
+func newBloomStage(capacity int, errorRate float64) *bloomStage {
+	capacity = max(capacity, 1)
+	size := uint64(math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2)))
+	size = max(size, 64)
+	hashes := int(math.Ceil(float64(size) / float64(capacity) * math.Ln2))
+
+	return &bloomStage{
+		bits:     make([]uint64, (size+63)/64),
+		size:     size,
+		hashes:   max(hashes, 1),
+		capacity: capacity,
+	}
+}
+
+// positions derives the bits of a key from two hashes (Kirsch-Mitzenmacher double hashing).
+// Both are reduced modulo the size first, the step is never zero so the bits do not all fall on
+// the first one, and the sum never wraps around.
+func (s *bloomStage) positions(h1, h2 uint64, visit func(word int, mask uint64) bool) bool {
+	bit := h1 % s.size
+	step := h2%(s.size-1) + 1
+	for i := 0; i < s.hashes; i++ {
+		if !visit(int(bit/64), 1<<(bit%64)) {
+			return false
+		}
+		bit = (bit + step) % s.size
+	}
+	return true
+}
+
+func (s *bloomStage) add(h1, h2 uint64) {
+	s.positions(h1, h2, func(word int, mask uint64) bool {
+		s.bits[word] |= mask
+		return true
+	})
+	s.count++
+}
+
+func (s *bloomStage) test(h1, h2 uint64) bool {
+	return s.positions(h1, h2, func(word int, mask uint64) bool {
+		return s.bits[word]&mask != 0
+	})
+}
+
+func (f *BloomFilter) hash(key []byte) (uint64, uint64) {
+	return maphash.Bytes(f.seeds[0], key), maphash.Bytes(f.seeds[1], key)
+}
+
+// Add inserts the key
+func (f *BloomFilter) Add(key []byte) {
+	h1, h2 := f.hash(key)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	last := f.stages[len(f.stages)-1]
+	if last.count >= last.capacity {
+		errorRate := f.errorRate / math.Pow(2, float64(len(f.stages)+1))
+		last = newBloomStage(last.capacity*2, errorRate)
+		f.stages = append(f.stages, last)
+	}
+	last.add(h1, h2)
+}
+
+// Test reports whether the key may have been added, false means it certainly was not
+func (f *BloomFilter) Test(key []byte) bool {
+	h1, h2 := f.hash(key)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, stage := range f.stages {
+		if stage.test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// SizeBytes is the memory taken by the bits of the filter
+func (f *BloomFilter) SizeBytes() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	size := 0
+	for _, stage := range f.stages {
+		size += len(stage.bits) * 8
+	}
+	return size
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/abadojack/whatlanggo"
+)
+
+// the trigram profiles settle long before the end of a page, no need to scan all of it
+const languageSampleLength = 4000
+
+// DetectLanguage guesses the ISO 639-1 code of the text from its trigrams.
+// It returns an empty code when the text is too short or too mixed to be reliable.
+func DetectLanguage(text string) (string, float64) {
+	if len(text) > languageSampleLength {
+		text = strings.ToValidUTF8(text[:languageSampleLength], "")
+	}
+
+	info := whatlanggo.Detect(text)
+	if !info.IsReliable() {
+		return "", info.Confidence
+	}
+	return info.Lang.Iso6391(), info.Confidence
+}
+
+// NormalizeLanguage turns tags like "en-US", "en_GB" or "AR" into a two letter code
+func NormalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if len(tag) != 2 {
+		return ""
+	}
+	for _, r := range tag {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+	return tag
+}
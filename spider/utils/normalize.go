@@ -0,0 +1,262 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/idna"
+)
+
+// URL normalization, every url goes through NormalizeURL before it is queued, checked against
+// the visited set or stored, so one page always ends up under one key.
+
+// URLRules tells which query parameters change the content on a domain
+type URLRules struct {
+	// when set, only these parameters are kept (e.g. ["id", "page"])
+	KeepParams []string `json:"keep_params"`
+	// dropped on top of the tracking parameters (e.g. ["sort", "view"])
+	DropParams []string `json:"drop_params"`
+}
+
+var (
+	urlRulesMu sync.RWMutex
+	urlRules   = make(map[string]URLRules)
+)
+
+// tracking and session parameters never change what the page shows
+var ignoredParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "gbraid": true, "wbraid": true, "msclkid": true,
+	"yclid": true, "twclid": true, "ttclid": true, "igshid": true, "mc_cid": true, "mc_eid": true,
+	"_ga": true, "_gl": true, "_hsenc": true, "_hsmi": true, "mkt_tok": true, "oly_anon_id": true,
+	"oly_enc_id": true, "vero_id": true, "wickedid": true, "ref_src": true,
+	"phpsessid": true, "jsessionid": true, "sessionid": true, "sessid": true,
+}
+
+// SetURLRules registers the query parameter rules of a domain, they also apply to its subdomains
+func SetURLRules(domain string, rules URLRules) {
+	urlRulesMu.Lock()
+	defer urlRulesMu.Unlock()
+	urlRules[strings.ToLower(strings.TrimPrefix(domain, "www."))] = rules
+}
+
+// LoadURLRules reads per-domain rules from a JSON file shaped like
+// {"example.com": {"keep_params": ["id"]}, "shop.com": {"drop_params": ["sort"]}}
+func LoadURLRules(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read url rules: %w", err)
+	}
+
+	rules := make(map[string]URLRules)
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("failed to parse url rules: %w", err)
+	}
+
+	for domain, rule := range rules {
+		SetURLRules(domain, rule)
+	}
+	return nil
+}
+
+// rulesFor returns the rules of the host or of its closest parent domain
+func rulesFor(host string) (URLRules, bool) {
+	urlRulesMu.RLock()
+	defer urlRulesMu.RUnlock()
+
+	if len(urlRules) == 0 {
+		return URLRules{}, false
+	}
+	for domain := host; domain != ""; {
+		if rules, ok := urlRules[domain]; ok {
+			return rules, true
+		}
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return URLRules{}, false
+}
+
+// NormalizeURL returns the canonical form of an http(s) url:
+// lowercase scheme and host, punycode hosts, no default port, no fragment, dot segments
+// resolved, tracking parameters removed, remaining parameters sorted and no trailing slash.
+//
+// Why no trailing slash ?
+// the qdrant point id is a hash of the url (GenerateUUIDFromURL) because qdrant only accepts
+// integers or uuids as ids, https://google.com and https://google.com/ would hash differently
+// and end up as two copies of the same page.
+func NormalizeURL(raw string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return "", fmt.Errorf("missing host in %q", raw)
+	}
+
+	host, err := normalizeHost(parsed.Hostname())
+	if err != nil {
+		return "", err
+	}
+	port := parsed.Port()
+	if (parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	switch {
+	case port != "":
+		// brackets an IPv6 address
+		parsed.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		parsed.Host = "[" + host + "]"
+	default:
+		parsed.Host = host
+	}
+
+	escapedPath := removeDotSegments(stripSessionParams(parsed.EscapedPath()))
+	escapedPath = strings.TrimRight(escapedPath, "/")
+	unescaped, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return "", err
+	}
+	parsed.Path = unescaped
+	parsed.RawPath = escapedPath
+
+	rules, _ := rulesFor(strings.TrimPrefix(host, "www."))
+	parsed.RawQuery = normalizeQuery(parsed.RawQuery, rules)
+	parsed.ForceQuery = false
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	parsed.User = nil
+
+	return parsed.String(), nil
+}
+
+// like idna.Lookup but it lets underscores through, they are common in real host names
+var hostProfile = idna.New(idna.MapForLookup(), idna.Transitional(true), idna.StrictDomainName(false))
+
+func normalizeHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	// IP addresses are returned bare, IPv6 ones get their brackets back with the port
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+
+	ascii, err := hostProfile.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid host %q: %w", host, err)
+	}
+	return ascii, nil
+}
+
+// stripSessionParams removes ;jsessionid=... style path parameters
+func stripSessionParams(path string) string {
+	lower := strings.ToLower(path)
+	for _, param := range []string{";jsessionid=", ";phpsessid=", ";sessionid="} {
+		if i := strings.Index(lower, param); i >= 0 {
+			end := strings.IndexAny(path[i+1:], ";/")
+			if end < 0 {
+				path = path[:i]
+			} else {
+				path = path[:i] + path[i+1+end:]
+			}
+			lower = strings.ToLower(path)
+		}
+	}
+	return path
+}
+
+// removeDotSegments resolves "." and ".." segments as described in RFC 3986 section 5.2.4
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	output := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		switch segment {
+		case ".":
+			if i == len(segments)-1 {
+				output = append(output, "")
+			}
+		case "..":
+			if len(output) > 1 {
+				output = output[:len(output)-1]
+			}
+			if i == len(segments)-1 {
+				output = append(output, "")
+			}
+		default:
+			output = append(output, segment)
+		}
+	}
+	return strings.Join(output, "/")
+}
+
+// normalizeQuery drops tracking parameters and the ones the domain rules ignore, then sorts the
+// rest by name. Pairs keep their original encoding so "+" and "%20" are not rewritten.
+func normalizeQuery(rawQuery string, rules URLRules) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	keep := make(map[string]bool, len(rules.KeepParams))
+	for _, name := range rules.KeepParams {
+		keep[strings.ToLower(name)] = true
+	}
+	drop := make(map[string]bool, len(rules.DropParams))
+	for _, name := range rules.DropParams {
+		drop[strings.ToLower(name)] = true
+	}
+
+	type pair struct {
+		key string
+		raw string
+	}
+	pairs := make([]pair, 0)
+	for _, raw := range strings.FieldsFunc(rawQuery, func(r rune) bool { return r == '&' || r == ';' }) {
+		name := raw
+		if i := strings.IndexByte(raw, '='); i >= 0 {
+			name = raw[:i]
+		}
+		key, err := url.QueryUnescape(name)
+		if err != nil {
+			key = name
+		}
+		lower := strings.ToLower(key)
+
+		if key == "" || strings.HasPrefix(lower, "utm_") || ignoredParams[lower] || drop[lower] {
+			continue
+		}
+		if len(keep) > 0 && !keep[lower] {
+			continue
+		}
+		pairs = append(pairs, pair{key: key, raw: raw})
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].key != pairs[j].key {
+			return pairs[i].key < pairs[j].key
+		}
+		return pairs[i].raw < pairs[j].raw
+	})
+
+	raws := make([]string, len(pairs))
+	for i, p := range pairs {
+		raws[i] = p.raw
+	}
+	return strings.Join(raws, "&")
+}
@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SeenSet remembers the urls the crawler is done with. Implementations are safe for concurrent use.
+type SeenSet interface {
+	Add(urls ...string) error
+	Remove(url string) error
+	Contains(url string) bool
+	Len() int
+	Close() error
+}
+
+// MemorySeenSet keeps the urls in a map, fine for crawls of a few million urls
+type MemorySeenSet struct {
+	mu   sync.RWMutex
+	urls map[string]struct{}
+}
+
+func NewMemorySeenSet() *MemorySeenSet {
+	return &MemorySeenSet{urls: make(map[string]struct{})}
+}
+
+func (s *MemorySeenSet) Add(urls ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, url := range urls {
+		s.urls[url] = struct{}{}
+	}
+	return nil
+}
+
+func (s *MemorySeenSet) Remove(url string) error {
+	s.mu.Lock()
+	delete(s.urls, url)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemorySeenSet) Contains(url string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, seen := s.urls[url]
+	return seen
+}
+
+func (s *MemorySeenSet) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.urls)
+}
+
+func (s *MemorySeenSet) Close() error {
+	return nil
+}
+
+const (
+	// keys the first stage of the Bloom filter is sized for, it grows past that
+	seenFilterCapacity  = 1 << 20
+	seenFilterErrorRate = 0.01
+	// urls written per transaction when many are added at once
+	seenWriteChunk = 10000
+)
+
+var seenBucket = []byte("seen")
+
+// DiskSeenSet keeps a 16 byte hash of every url in a bbolt file, with a Bloom filter in front of it
+// so new urls (most of the lookups) never touch the disk. Memory stays around 1.2 bytes per url
+// and the set survives restarts.
+type DiskSeenSet struct {
+	db     *bolt.DB
+	filter *BloomFilter
+	mu     sync.Mutex
+	count  int
+}
+
+// OpenDiskSeenSet opens (or creates) the set stored at path and loads its Bloom filter
+func OpenDiskSeenSet(path string) (*DiskSeenSet, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create seen set directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open seen set %s: %w", path, err)
+	}
+
+	set := &DiskSeenSet{db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(seenBucket)
+		if err != nil {
+			return err
+		}
+
+		set.count = bucket.Stats().KeyN
+		set.filter = NewBloomFilter(max(set.count, seenFilterCapacity), seenFilterErrorRate)
+		return bucket.ForEach(func(key, _ []byte) error {
+			set.filter.Add(key)
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load seen set %s: %w", path, err)
+	}
+	return set, nil
+}
+
+func seenKey(url string) []byte {
+	sum := sha256.Sum256([]byte(url))
+	return sum[:16]
+}
+
+func (s *DiskSeenSet) Add(urls ...string) error {
+	for start := 0; start < len(urls); start += seenWriteChunk {
+		keys := make([][]byte, 0, min(seenWriteChunk, len(urls)-start))
+		for _, url := range urls[start:min(start+seenWriteChunk, len(urls))] {
+			keys = append(keys, seenKey(url))
+		}
+
+		added := make([][]byte, 0, len(keys))
+		// Batch merges the writes of concurrent workers into one transaction, it may run the function twice
+		err := s.db.Batch(func(tx *bolt.Tx) error {
+			added = added[:0]
+			bucket := tx.Bucket(seenBucket)
+			for _, key := range keys {
+				if bucket.Get(key) != nil {
+					continue
+				}
+				if err := bucket.Put(key, nil); err != nil {
+					return err
+				}
+				added = append(added, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add to seen set: %w", err)
+		}
+
+		// a key added again after a Remove still has its bits, counting it twice would fill the stages early
+		for _, key := range added {
+			if !s.filter.Test(key) {
+				s.filter.Add(key)
+			}
+		}
+		s.mu.Lock()
+		s.count += len(added)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Remove forgets the url, its bits stay in the Bloom filter and only cost a disk lookup
+func (s *DiskSeenSet) Remove(url string) error {
+	key := seenKey(url)
+	if !s.filter.Test(key) {
+		return nil
+	}
+
+	removed := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(seenBucket)
+		removed = bucket.Get(key) != nil
+		return bucket.Delete(key)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove from seen set: %w", err)
+	}
+
+	if removed {
+		s.mu.Lock()
+		s.count--
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Contains reports whether the url was added, a failed disk read counts as not seen
+func (s *DiskSeenSet) Contains(url string) bool {
+	key := seenKey(url)
+	if !s.filter.Test(key) {
+		return false
+	}
+
+	seen := false
+	s.db.View(func(tx *bolt.Tx) error {
+		seen = tx.Bucket(seenBucket).Get(key) != nil
+		return nil
+	})
+	return seen
+}
+
+func (s *DiskSeenSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.count
+}
+
+func (s *DiskSeenSet) Close() error {
+	return s.db.Close()
+}
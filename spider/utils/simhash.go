@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+)
+
+// words per shingle, small enough to survive minor edits and big enough to keep word order
+const simHashShingleSize = 3
+
+// SimHash computes a 64 bit fingerprint of the text.
+// Texts that share most of their word shingles get fingerprints with a small hamming distance,
+// so mirrors, print views and templated copies of a page end up a few bits apart.
+func SimHash(text string) uint64 {
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) == 0 {
+		return 0
+	}
+
+	var weights [64]int
+	addFeature := func(feature string) {
+		hasher := fnv.New64a()
+		hasher.Write([]byte(feature))
+		hash := hasher.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if hash&(1<<uint(bit)) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	if len(words) < simHashShingleSize {
+		for _, word := range words {
+			addFeature(word)
+		}
+	} else {
+		for i := 0; i+simHashShingleSize <= len(words); i++ {
+			addFeature(strings.Join(words[i:i+simHashShingleSize], " "))
+		}
+	}
+
+	var fingerprint uint64
+	for bit := 0; bit < 64; bit++ {
+		if weights[bit] > 0 {
+			fingerprint |= 1 << uint(bit)
+		}
+	}
+	return fingerprint
+}
+
+// HammingDistance counts the bits that differ between two fingerprints
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// SimHashBands splits a fingerprint in four 16 bit bands.
+// Two fingerprints within 3 bits of each other always share at least one band,
+// which lets the database find near-duplicate candidates with plain index lookups.
+func SimHashBands(fingerprint uint64) [4]int {
+	var bands [4]int
+	for i := range bands {
+		bands[i] = int((fingerprint >> (16 * uint(i))) & 0xffff)
+	}
+	return bands
+}
@@ -5,36 +5,51 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/froxy/models"
 )
 
-func Enqueue(queue []models.Link, element models.Link) []models.Link {
-	return append(queue, element)
+// dateLayouts covers the W3C datetime profile used by sitemaps and the formats
+// commonly found in HTTP headers and HTML metadata
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006/01/02",
+	time.RFC1123,
+	time.RFC1123Z,
+	time.RFC850,
+	time.ANSIC,
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"02 Jan 2006",
 }
 
-func Dequeue(queue []models.Link) (models.Link, []models.Link, error) {
-	if len(queue) == 0 {
-		return models.Link{}, queue, errors.New("queue is empty")
+// ParseDate parses the date formats we meet while crawling, the zero time is returned if none matches
+func ParseDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
 	}
-	element := queue[0]
-	return element, queue[1:], nil
-}
 
-func CanonicalizeURL(raw string) (string, error) {
-	parsed, err := url.Parse(raw)
-	if err != nil {
-		return "", err
+	for _, layout := range dateLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, true
+		}
 	}
-	parsed.Fragment = ""
-	parsed.RawQuery = ""
-	return parsed.String(), nil
+	return time.Time{}, false
 }
 
 func GenerateUUIDFromURL(url string) string {